@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/action"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/urfave/cli/v2"
+)
+
+// certFilterFlags are the flags used to select certificates
+var certFilterFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "name",
+		Usage: "Only certificates whose name matches this glob pattern",
+	},
+	&cli.StringFlag{
+		Name:  "common-name",
+		Usage: "Only certificates whose common name matches this glob pattern",
+	},
+	&cli.BoolFlag{
+		Name:  "expired",
+		Usage: "Only expired certificates",
+	},
+	&cli.IntFlag{
+		Name:  "expires-within",
+		Usage: "Only certificates expiring within this number of days",
+	},
+	&cli.BoolFlag{
+		Name:  "bound",
+		Usage: "Only certificates bound to a domain",
+	},
+	&cli.BoolFlag{
+		Name:  "unbound",
+		Usage: "Only certificates not bound to any domain",
+	},
+}
+
+// certsCommand returns the "certs" command and its subcommands
+func certsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "certs",
+		Usage: "Manage SSL certificates in the Qiniu account",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List certificates",
+				Flags: certFilterFlags,
+				Action: func(c *cli.Context) error {
+					certs, bindings, err := selectCertificates(c)
+					if err != nil {
+						return err
+					}
+
+					printCertificates(certs, bindings)
+					return nil
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Show the details of a certificate",
+				ArgsUsage: "<cert ID>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected exactly one certificate ID")
+					}
+
+					qiniuClient, err := newQiniuClient(c)
+					if err != nil {
+						return err
+					}
+
+					cert, err := qiniuClient.GetCertificateInfo(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					bindings, err := qiniuClient.GetCertificateBindings(c.Context)
+					if err != nil {
+						return err
+					}
+
+					printCertificate(cert, bindings[cert.ID])
+					return nil
+				},
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete certificates by ID or by filter",
+				ArgsUsage: "[cert ID...]",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Delete the certificates instead of only previewing them",
+					},
+				}, certFilterFlags...),
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 && !hasCertFilter(c) {
+						return fmt.Errorf("specify certificate IDs or at least one filter")
+					}
+
+					certs, bindings, err := selectCertificates(c)
+					if err != nil {
+						return err
+					}
+
+					return deleteCertificates(c, certs, bindings, !c.Bool("yes"))
+				},
+			},
+			{
+				Name:  "gc",
+				Usage: "Delete superseded certificates uploaded by this tool that are no longer bound to a domain",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "grace-period",
+						Usage: "How long to keep superseded certificates before deleting them",
+						Value: 72 * time.Hour,
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Delete the certificates instead of only previewing them",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					qiniuClient, err := newQiniuClient(c)
+					if err != nil {
+						return err
+					}
+
+					return action.CollectCertificates(c.Context, qiniuClient, c.String("cert-dir"), c.Duration("grace-period"), !c.Bool("yes"))
+				},
+			},
+		},
+	}
+}
+
+// newQiniuClient creates a Qiniu client from the global flags
+func newQiniuClient(c *cli.Context) (*qiniuapi.QiniuClient, error) {
+	qiniuAccessKey := c.String("qiniu-access-key")
+	qiniuSecretKey := c.String("qiniu-secret-key")
+
+	if qiniuAccessKey == "" || qiniuSecretKey == "" {
+		return nil, fmt.Errorf("qiniu access key and secret key are required")
+	}
+
+	qiniuClient, err := qiniuapi.NewQiniuClient(qiniuAccessKey, qiniuSecretKey,
+		qiniuapi.WithBaseURL(c.String("qiniu-api-host")),
+		qiniuapi.WithUCURL(c.String("qiniu-uc-host")),
+		qiniuapi.WithPiliURL(c.String("qiniu-pili-host")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Qiniu client: %v", err)
+	}
+
+	return qiniuClient, nil
+}
+
+// hasCertFilter checks whether any certificate filter flag is set
+func hasCertFilter(c *cli.Context) bool {
+	for _, flag := range certFilterFlags {
+		if c.IsSet(flag.Names()[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectCertificates lists the certificates matching the command arguments and filters,
+// along with the domains each certificate is bound to
+func selectCertificates(c *cli.Context) ([]qiniuapi.CertificateInfo, map[string][]string, error) {
+	if c.Bool("bound") && c.Bool("unbound") {
+		return nil, nil, fmt.Errorf("--bound and --unbound cannot be used together")
+	}
+
+	qiniuClient, err := newQiniuClient(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certs, err := qiniuClient.ListAllCertificates(c.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bindings, err := qiniuClient.GetCertificateBindings(c.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make(map[string]bool)
+	for _, id := range c.Args().Slice() {
+		ids[id] = true
+	}
+
+	var selected []qiniuapi.CertificateInfo
+	for _, cert := range certs {
+		if len(ids) > 0 && !ids[cert.ID] {
+			continue
+		}
+		if matchCertificate(c, cert, len(bindings[cert.ID]) > 0) {
+			selected = append(selected, cert)
+		}
+	}
+
+	return selected, bindings, nil
+}
+
+// matchCertificate checks whether a certificate matches the filter flags
+func matchCertificate(c *cli.Context, cert qiniuapi.CertificateInfo, bound bool) bool {
+	if pattern := c.String("name"); pattern != "" {
+		if ok, _ := path.Match(pattern, cert.Name); !ok {
+			return false
+		}
+	}
+
+	if pattern := c.String("common-name"); pattern != "" {
+		if ok, _ := path.Match(pattern, cert.Common); !ok {
+			return false
+		}
+	}
+
+	expiresAt := time.Unix(cert.NotAfter, 0)
+	if c.Bool("expired") && expiresAt.After(time.Now()) {
+		return false
+	}
+
+	if days := c.Int("expires-within"); days > 0 && expiresAt.After(time.Now().AddDate(0, 0, days)) {
+		return false
+	}
+
+	if c.Bool("bound") && !bound {
+		return false
+	}
+
+	if c.Bool("unbound") && bound {
+		return false
+	}
+
+	return true
+}
+
+// printCertificates prints certificates as a table
+func printCertificates(certs []qiniuapi.CertificateInfo, bindings map[string][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCOMMON NAME\tNOT AFTER\tMANAGED\tDOMAINS")
+	for _, cert := range certs {
+		domains := "-"
+		if len(bindings[cert.ID]) > 0 {
+			domains = strings.Join(bindings[cert.ID], ",")
+		}
+		managed := "no"
+		if cert.UploadedByTool() {
+			managed = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", cert.ID, cert.Name, cert.Common,
+			time.Unix(cert.NotAfter, 0).Format("2006-01-02"), managed, domains)
+	}
+	w.Flush()
+}
+
+// printCertificate prints the details of a certificate
+func printCertificate(cert *qiniuapi.CertificateInfo, domains []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", cert.ID)
+	fmt.Fprintf(w, "Name:\t%s\n", cert.Name)
+	fmt.Fprintf(w, "Common name:\t%s\n", cert.Common)
+	fmt.Fprintf(w, "DNS names:\t%s\n", strings.Join(cert.DNSNames, ", "))
+	fmt.Fprintf(w, "Not before:\t%s\n", time.Unix(cert.NotBefore, 0).Format(time.RFC3339))
+	fmt.Fprintf(w, "Not after:\t%s\n", time.Unix(cert.NotAfter, 0).Format(time.RFC3339))
+	fmt.Fprintf(w, "Uploaded:\t%s\n", time.Unix(cert.CreateTime, 0).Format(time.RFC3339))
+	fmt.Fprintf(w, "Description:\t%s\n", cert.Description)
+	if cert.Marker != nil {
+		fmt.Fprintf(w, "Managed by:\t%s\n", qiniuapi.ToolTag)
+		fmt.Fprintf(w, "Issuer:\t%s\n", cert.Marker.Issuer)
+		fmt.Fprintf(w, "Serial:\t%s\n", cert.Marker.Serial)
+		fmt.Fprintf(w, "SHA-256:\t%s\n", cert.Marker.Fingerprint)
+		fmt.Fprintf(w, "Issued:\t%s\n", cert.Marker.IssuedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Domains:\t%s\n", strings.Join(domains, ", "))
+	w.Flush()
+}
+
+// deleteCertificates deletes the given certificates, skipping the ones bound to a domain
+func deleteCertificates(c *cli.Context, certs []qiniuapi.CertificateInfo, bindings map[string][]string, dryRun bool) error {
+	var unbound []qiniuapi.CertificateInfo
+	for _, cert := range certs {
+		if domains := bindings[cert.ID]; len(domains) > 0 {
+			log.Printf("Skipping certificate %s (%s): bound to %s", cert.ID, cert.Name, strings.Join(domains, ", "))
+			continue
+		}
+		unbound = append(unbound, cert)
+	}
+
+	if len(unbound) == 0 {
+		log.Printf("No certificates to delete")
+		return nil
+	}
+
+	printCertificates(unbound, bindings)
+
+	if dryRun {
+		log.Printf("Dry run: %d certificates would be deleted, run with --yes to delete them", len(unbound))
+		return nil
+	}
+
+	qiniuClient, err := newQiniuClient(c)
+	if err != nil {
+		return err
+	}
+
+	for _, cert := range unbound {
+		if err := qiniuClient.DeleteCertificate(c.Context, cert.ID); err != nil {
+			return fmt.Errorf("failed to delete certificate %s: %w", cert.ID, err)
+		}
+		log.Printf("Deleted certificate %s (%s)", cert.ID, cert.Name)
+	}
+
+	return nil
+}
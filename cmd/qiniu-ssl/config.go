@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/WqyJh/qiniu-ssl/internal/action"
+)
+
+// domainConfig is a domain to manage along with its own settings.
+// In a domains file each line holds a domain followed by optional settings:
+//
+//	<domain> [force-https=true|false] [http2=true|false] [target=cdn|kodo|pili] [bucket=<bucket>] [hub=<hub>]
+//
+// Kodo bucket domains require the bucket they are bound to, live-streaming domains their hub.
+type domainConfig struct {
+	Name       string
+	ForceHTTPS *bool  // nil to use the global flag
+	HTTP2      *bool  // nil to use the global flag
+	Target     string // Deployment target, action.TargetCDN if empty
+	Bucket     string // Kodo bucket of the domain
+	Hub        string // Live-streaming hub of the domain
+}
+
+// parseDomainLine parses a line of a domains file
+func parseDomainLine(line string) (domainConfig, error) {
+	fields := strings.Fields(line)
+	config := domainConfig{Name: fields[0]}
+
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return config, fmt.Errorf("invalid setting %q for domain %s, expected key=value", field, config.Name)
+		}
+
+		switch key {
+		case "force-https":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid force-https value %q for domain %s", value, config.Name)
+			}
+			config.ForceHTTPS = &b
+		case "http2":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid http2 value %q for domain %s", value, config.Name)
+			}
+			config.HTTP2 = &b
+		case "target":
+			if value != action.TargetCDN && value != action.TargetKodo && value != action.TargetPili {
+				return config, fmt.Errorf("invalid target %q for domain %s, expected %s, %s or %s",
+					value, config.Name, action.TargetCDN, action.TargetKodo, action.TargetPili)
+			}
+			config.Target = value
+		case "bucket":
+			config.Bucket = value
+		case "hub":
+			config.Hub = value
+		default:
+			return config, fmt.Errorf("unknown setting %q for domain %s", key, config.Name)
+		}
+	}
+
+	if config.Target == action.TargetKodo && config.Bucket == "" {
+		return config, fmt.Errorf("bucket is required for Kodo domain %s", config.Name)
+	}
+	if config.Target != action.TargetKodo && config.Bucket != "" {
+		return config, fmt.Errorf("bucket is only valid with target=%s for domain %s", action.TargetKodo, config.Name)
+	}
+	if config.Target == action.TargetPili && config.Hub == "" {
+		return config, fmt.Errorf("hub is required for live-streaming domain %s", config.Name)
+	}
+	if config.Target != action.TargetPili && config.Hub != "" {
+		return config, fmt.Errorf("hub is only valid with target=%s for domain %s", action.TargetPili, config.Name)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/WqyJh/qiniu-ssl/internal/action"
+	"github.com/WqyJh/qiniu-ssl/internal/certcheck"
+	"github.com/urfave/cli/v2"
+)
+
+// deployCommand returns the "deploy" command
+func deployCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "deploy",
+		Usage: "Upload an existing certificate and bind it to Qiniu CDN domains, without ACME",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "cert",
+				Usage:    "Path to the PEM encoded certificate, optionally followed by its chain",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "chain",
+				Usage: "Path to the PEM encoded intermediate certificates",
+			},
+			&cli.StringFlag{
+				Name:     "key",
+				Usage:    "Path to the PEM encoded private key",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:     "domain",
+				Aliases:  []string{"d"},
+				Usage:    "Qiniu domain to bind the certificate to, may be repeated",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "bucket",
+				Usage: "Kodo bucket the domains are bound to, to deploy to Kodo bucket domains instead of CDN domains",
+			},
+			&cli.StringFlag{
+				Name:  "hub",
+				Usage: "Live-streaming hub of the domains, to deploy to live-streaming domains instead of CDN domains",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.IsSet("bucket") && c.IsSet("hub") {
+				return fmt.Errorf("--bucket and --hub cannot be used together")
+			}
+
+			certPEM, keyPEM, err := loadCertificateFiles(c.String("cert"), c.String("chain"), c.String("key"))
+			if err != nil {
+				return err
+			}
+
+			qiniuClient, err := newQiniuClient(c)
+			if err != nil {
+				return err
+			}
+
+			// The certificate directory holds the certificate cache and deployment history
+			if err := os.MkdirAll(c.String("cert-dir"), 0700); err != nil {
+				return fmt.Errorf("failed to create certificate directory: %v", err)
+			}
+
+			var failed []string
+			for _, domain := range c.StringSlice("domain") {
+				if err := c.Context.Err(); err != nil {
+					return err
+				}
+
+				opts := deployOptions(c, domain)
+				if bucket := c.String("bucket"); bucket != "" {
+					opts.Target = action.TargetKodo
+					opts.Bucket = bucket
+				}
+				if hub := c.String("hub"); hub != "" {
+					opts.Target = action.TargetPili
+					opts.Hub = hub
+				}
+
+				if err := action.Deploy(c.Context, qiniuClient, certPEM, keyPEM, opts); err != nil {
+					log.Printf("Failed to deploy certificate to %s: %v", domain, err)
+					failed = append(failed, domain)
+				}
+			}
+
+			if len(failed) > 0 {
+				return fmt.Errorf("failed to deploy certificate to %s", strings.Join(failed, ", "))
+			}
+
+			return nil
+		},
+	}
+}
+
+// loadCertificateFiles reads the certificate, chain and key files and validates them.
+// It returns the certificate followed by its chain, and the key.
+func loadCertificateFiles(certPath, chainPath, keyPath string) ([]byte, []byte, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+
+	if chainPath != "" {
+		chainPEM, err := os.ReadFile(chainPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read certificate chain: %v", err)
+		}
+		certPEM = append(bytes.TrimRight(certPEM, "\n"), '\n')
+		certPEM = append(certPEM, chainPEM...)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+
+	// Whether the certificate covers each domain is checked when it is deployed
+	if err := certcheck.Validate(certPEM, keyPEM, ""); err != nil {
+		return nil, nil, err
+	}
+
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/aliyundns"
+	"github.com/WqyJh/qiniu-ssl/internal/manualdns"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/urfave/cli/v2"
+)
+
+// dnsCommand returns the "dns" command and its subcommands
+func dnsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "dns",
+		Usage: "Manage DNS records in Aliyun DNS",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "sweep",
+				Usage: "Delete stale _acme-challenge TXT records left behind by interrupted runs",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "min-age",
+						Usage: "Only delete records older than this",
+						Value: time.Hour,
+					},
+					&cli.BoolFlag{
+						Name:  "include-unknown-age",
+						Usage: "Also delete records created by qiniu-ssl whose creation time cannot be read from the remark",
+						Value: false,
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Delete the records instead of only previewing them",
+						Value:   false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					provider, err := newAliyunDNSProvider(c)
+					if err != nil {
+						return err
+					}
+
+					return sweepChallengeRecords(provider, c.Duration("min-age"), c.Bool("include-unknown-age"), !c.Bool("yes"))
+				},
+			},
+		},
+	}
+}
+
+// newChallengeProvider creates the DNS provider for the ACME DNS-01 challenge,
+// manual if --manual-dns is set and Aliyun DNS otherwise
+func newChallengeProvider(c *cli.Context) (challenge.Provider, error) {
+	if c.Bool("manual-dns") {
+		provider, err := manualdns.NewDNSProvider(c.Bool("manual-dns-poll"), c.Duration("manual-dns-timeout"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create DNS provider: %v", err)
+		}
+		return provider, nil
+	}
+
+	provider, err := newAliyunDNSProvider(c)
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// newAliyunDNSProvider creates an Aliyun DNS provider from the global flags
+func newAliyunDNSProvider(c *cli.Context) (*aliyundns.DNSProvider, error) {
+	aliyunAccessKey := c.String("aliyun-access-key")
+	aliyunSecretKey := c.String("aliyun-secret-key")
+	aliyunRegion := c.String("aliyun-region")
+
+	if aliyunAccessKey == "" || aliyunSecretKey == "" {
+		return nil, fmt.Errorf("aliyun access key and secret key are required")
+	}
+
+	provider, err := aliyundns.NewDNSProvider(aliyunAccessKey, aliyunSecretKey, aliyunRegion,
+		aliyundns.WithEndpoint(c.String("aliyun-endpoint")),
+		aliyundns.WithTTL(c.Int64("aliyun-dns-ttl")),
+		aliyundns.WithLine(c.String("aliyun-dns-line")),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS provider: %v", err)
+	}
+
+	return provider, nil
+}
+
+// sweepChallengeRecords lists the _acme-challenge TXT records in all managed zones
+// and deletes the ones created by this tool that are older than minAge. Records whose age
+// is unknown are only deleted with includeUnknownAge, as they may belong to a running challenge.
+func sweepChallengeRecords(provider *aliyundns.DNSProvider, minAge time.Duration, includeUnknownAge, dryRun bool) error {
+	records, err := provider.ListChallengeRecords()
+	if err != nil {
+		return fmt.Errorf("failed to list challenge records: %v", err)
+	}
+
+	var stale []aliyundns.ChallengeRecord
+	for _, record := range records {
+		age := "unknown"
+		if !record.CreatedAt.IsZero() {
+			age = record.Age().Truncate(time.Minute).String()
+		}
+
+		switch {
+		case !record.Owned:
+			log.Printf("Skipping %s (%s): not created by qiniu-ssl", record.FQDN(), record.Value)
+		case record.CreatedAt.IsZero() && !includeUnknownAge:
+			log.Printf("Skipping %s (%s): age unknown, run with --include-unknown-age to delete it", record.FQDN(), record.Value)
+		case !record.CreatedAt.IsZero() && record.Age() < minAge:
+			log.Printf("Skipping %s (%s): age %s is below %s", record.FQDN(), record.Value, age, minAge)
+		default:
+			log.Printf("Stale record %s (%s): age %s", record.FQDN(), record.Value, age)
+			stale = append(stale, record)
+		}
+	}
+
+	if len(stale) == 0 {
+		log.Printf("No stale challenge records found")
+		return nil
+	}
+
+	if dryRun {
+		log.Printf("Dry run: %d stale challenge records would be deleted, run with --yes to delete them", len(stale))
+		return nil
+	}
+
+	for _, record := range stale {
+		if err := provider.DeleteRecord(record.RecordID); err != nil {
+			return fmt.Errorf("failed to delete record %s: %v", record.FQDN(), err)
+		}
+		log.Printf("Deleted stale record %s", record.FQDN())
+	}
+
+	return nil
+}
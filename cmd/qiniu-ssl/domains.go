@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/action"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/urfave/cli/v2"
+)
+
+// discoverFlags are the flags used to select the domains managed in discovery mode
+var discoverFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "discover",
+		Usage: "Manage every CDN domain of the Qiniu account matching the discovery filters",
+		Value: false,
+	},
+	&cli.StringSliceFlag{
+		Name:  "include",
+		Usage: "In discovery mode, only domains matching one of these glob patterns",
+	},
+	&cli.StringSliceFlag{
+		Name:  "exclude",
+		Usage: "In discovery mode, skip domains matching one of these glob patterns",
+	},
+	&cli.StringSliceFlag{
+		Name:  "domain-type",
+		Usage: "In discovery mode, only domains of these types (normal, wildcard, pan)",
+		Value: cli.NewStringSlice("normal"),
+	},
+	&cli.StringSliceFlag{
+		Name:  "platform",
+		Usage: "In discovery mode, only domains of these platforms (web, download, vod, dynamic)",
+	},
+}
+
+// domainsCommand returns the "domains" command and its subcommands
+func domainsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "domains",
+		Usage: "Show and create the domains of the Qiniu account",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List domains and their state",
+				Action: func(c *cli.Context) error {
+					qiniuClient, err := newQiniuClient(c)
+					if err != nil {
+						return err
+					}
+
+					domains, err := qiniuClient.ListAllDomains(c.Context)
+					if err != nil {
+						return err
+					}
+
+					printDomains(domains)
+					return nil
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Show the configuration of a domain",
+				ArgsUsage: "<domain>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected exactly one domain")
+					}
+
+					qiniuClient, err := newQiniuClient(c)
+					if err != nil {
+						return err
+					}
+
+					domain, err := qiniuClient.GetDomainInfo(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					printDomain(domain)
+					return nil
+				},
+			},
+			{
+				Name:      "origins",
+				Usage:     "Show the certificates served by the HTTPS origins of CDN domains",
+				ArgsUsage: "<domain>...",
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						return fmt.Errorf("expected at least one domain")
+					}
+
+					qiniuClient, err := newQiniuClient(c)
+					if err != nil {
+						return err
+					}
+
+					var origins []action.OriginCertificate
+					for _, domain := range c.Args().Slice() {
+						domainInfo, err := qiniuClient.GetDomainInfo(c.Context, domain)
+						if err != nil {
+							return err
+						}
+
+						probed := action.ProbeOrigins(c.Context, domainInfo)
+						if len(probed) == 0 {
+							log.Printf("Domain %s does not fetch from its origin over HTTPS", domain)
+						}
+						origins = append(origins, probed...)
+					}
+
+					printOrigins(origins, c.Int("threshold"))
+					return nil
+				},
+			},
+			{
+				Name:      "kodo",
+				Usage:     "List the custom domains of Kodo buckets and their certificates",
+				ArgsUsage: "[bucket...]",
+				Action: func(c *cli.Context) error {
+					qiniuClient, err := newQiniuClient(c)
+					if err != nil {
+						return err
+					}
+
+					var domains []qiniuapi.BucketDomain
+					if c.NArg() == 0 {
+						if domains, err = qiniuClient.ListAllBucketDomains(c.Context); err != nil {
+							return err
+						}
+					}
+					for _, bucket := range c.Args().Slice() {
+						page, err := qiniuClient.ListBucketDomains(c.Context, bucket)
+						if err != nil {
+							return err
+						}
+						domains = append(domains, page...)
+					}
+
+					printBucketDomains(domains)
+					return nil
+				},
+			},
+			{
+				Name:      "pili",
+				Usage:     "List the domains of live-streaming hubs and their certificates",
+				ArgsUsage: "[hub...]",
+				Action: func(c *cli.Context) error {
+					qiniuClient, err := newQiniuClient(c)
+					if err != nil {
+						return err
+					}
+
+					hubs := c.Args().Slice()
+					if len(hubs) == 0 {
+						listed, err := qiniuClient.ListPiliHubs(c.Context)
+						if err != nil {
+							return err
+						}
+						for _, hub := range listed {
+							hubs = append(hubs, hub.Name)
+						}
+					}
+
+					// The domain list may not include the certificate
+					domains := make(map[string][]qiniuapi.PiliDomain, len(hubs))
+					for _, hub := range hubs {
+						listed, err := qiniuClient.ListPiliDomains(c.Context, hub)
+						if err != nil {
+							return err
+						}
+						for _, domain := range listed {
+							info, err := qiniuClient.GetPiliDomain(c.Context, hub, domain.Domain)
+							if err != nil {
+								return err
+							}
+							domains[hub] = append(domains[hub], *info)
+						}
+					}
+
+					printPiliDomains(hubs, domains)
+					return nil
+				},
+			},
+			{
+				Name:      "create",
+				Usage:     "Create a domain, wait until it is online, then issue and bind its certificate",
+				ArgsUsage: "<domain>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "type",
+						Usage: "Domain type (normal, wildcard)",
+						Value: qiniuapi.DomainTypeNormal,
+					},
+					&cli.StringFlag{
+						Name:  "platform",
+						Usage: "Platform (web, download, vod, dynamic)",
+						Value: "web",
+					},
+					&cli.StringFlag{
+						Name:  "geo-cover",
+						Usage: "Coverage (china, foreign, global)",
+						Value: "china",
+					},
+					&cli.StringFlag{
+						Name:  "protocol",
+						Usage: "Protocol (http, https)",
+						Value: "https",
+					},
+					&cli.StringFlag{
+						Name:  "source-type",
+						Usage: "Origin type (domain, ip, qiniuBucket)",
+						Value: "domain",
+					},
+					&cli.StringSliceFlag{
+						Name:     "source",
+						Usage:    "Origin domain, IP addresses or Qiniu bucket, depending on the origin type",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "source-host",
+						Usage: "Host header sent to the origin, defaults to the domain",
+					},
+					&cli.StringFlag{
+						Name:  "source-scheme",
+						Usage: "Protocol used to fetch from the origin (http, https), defaults to the protocol of the request",
+					},
+					&cli.StringFlag{
+						Name:  "test-url-path",
+						Usage: "Path of a resource on the origin Qiniu checks before creating the domain",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected exactly one domain")
+					}
+					domain := c.Args().First()
+
+					req, err := createDomainRequest(c)
+					if err != nil {
+						return err
+					}
+
+					qiniuClient, err := newQiniuClient(c)
+					if err != nil {
+						return err
+					}
+
+					dnsProvider, err := newChallengeProvider(c)
+					if err != nil {
+						return err
+					}
+
+					if err := os.MkdirAll(c.String("cert-dir"), 0700); err != nil {
+						return fmt.Errorf("failed to create certificate directory: %v", err)
+					}
+
+					return action.CreateDomain(c.Context, qiniuClient, dnsProvider, req, deployOptions(c, domain))
+				},
+			},
+		},
+	}
+}
+
+// createDomainRequest builds the configuration of a new domain from the "domains create" flags
+func createDomainRequest(c *cli.Context) (qiniuapi.CreateDomainRequest, error) {
+	source := &qiniuapi.SourceInfo{
+		SourceType:      c.String("source-type"),
+		SourceHost:      c.String("source-host"),
+		SourceURLScheme: c.String("source-scheme"),
+		TestURLPath:     c.String("test-url-path"),
+	}
+
+	sources := c.StringSlice("source")
+	switch source.SourceType {
+	case "domain":
+		if len(sources) != 1 {
+			return qiniuapi.CreateDomainRequest{}, fmt.Errorf("expected exactly one origin domain")
+		}
+		source.SourceDomain = sources[0]
+	case "ip":
+		source.SourceIPs = sources
+	case "qiniuBucket":
+		if len(sources) != 1 {
+			return qiniuapi.CreateDomainRequest{}, fmt.Errorf("expected exactly one origin bucket")
+		}
+		source.SourceQiniuBucket = sources[0]
+	default:
+		return qiniuapi.CreateDomainRequest{}, fmt.Errorf("unsupported origin type %q", source.SourceType)
+	}
+
+	protocol := c.String("protocol")
+	if protocol != "http" && protocol != "https" {
+		return qiniuapi.CreateDomainRequest{}, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+
+	return qiniuapi.CreateDomainRequest{
+		Type:     c.String("type"),
+		Platform: c.String("platform"),
+		GeoCover: c.String("geo-cover"),
+		Protocol: protocol,
+		Source:   source,
+	}, nil
+}
+
+// domainFilter selects the discovered domains
+type domainFilter struct {
+	Include   []string
+	Exclude   []string
+	Types     []string
+	Platforms []string
+}
+
+// newDomainFilter creates a domain filter from the discovery flags
+func newDomainFilter(c *cli.Context) (*domainFilter, error) {
+	filter := &domainFilter{
+		Include:   c.StringSlice("include"),
+		Exclude:   c.StringSlice("exclude"),
+		Types:     c.StringSlice("domain-type"),
+		Platforms: c.StringSlice("platform"),
+	}
+
+	// Reject malformed patterns up front instead of silently matching nothing
+	for _, pattern := range append(filter.Include, filter.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid domain pattern %q: %v", pattern, err)
+		}
+	}
+
+	return filter, nil
+}
+
+// Match checks whether a domain matches the filter
+func (f *domainFilter) Match(domain qiniuapi.DomainInfo) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, domain.Name) {
+		return false
+	}
+
+	if matchAny(f.Exclude, domain.Name) {
+		return false
+	}
+
+	if len(f.Types) > 0 && !contains(f.Types, domain.Type) {
+		return false
+	}
+
+	if len(f.Platforms) > 0 && !contains(f.Platforms, domain.Platform) {
+		return false
+	}
+
+	return true
+}
+
+// discoverDomains selects the domains matching the filter among all CDN domains of the account
+func discoverDomains(domains []qiniuapi.DomainInfo, filter *domainFilter) []qiniuapi.DomainInfo {
+	var discovered []qiniuapi.DomainInfo
+	for _, domain := range domains {
+		if filter.Match(domain) {
+			discovered = append(discovered, domain)
+		}
+	}
+
+	log.Printf("Discovered %d of %d Qiniu domains", len(discovered), len(domains))
+	return discovered
+}
+
+// printDomains prints domains as a table
+func printDomains(domains []qiniuapi.DomainInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tPLATFORM\tPROTOCOL\tSTATE\tCNAME")
+	for _, domain := range domains {
+		state := domain.OperatingState
+		if domain.UIDIsFreezed {
+			state += " (account frozen)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", domain.Name, domain.Type, domain.Platform,
+			domain.Protocol, state, domain.CNAME)
+	}
+	w.Flush()
+}
+
+// printOrigins prints origin certificates as a table, flagging the ones expiring within thresholdDays
+func printOrigins(origins []action.OriginCertificate, thresholdDays int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tORIGIN\tHOST\tNOT AFTER\tSTATUS")
+	for _, origin := range origins {
+		notAfter, status := "-", "ok"
+		if origin.Certificate != nil {
+			notAfter = origin.Certificate.NotAfter.Format("2006-01-02")
+		}
+		switch {
+		case origin.Err != nil:
+			status = origin.Err.Error()
+		case origin.Expiring(thresholdDays):
+			status = "expiring"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", origin.Domain, origin.Addr, origin.Host, notAfter, status)
+	}
+	w.Flush()
+}
+
+// printBucketDomains prints Kodo bucket domains as a table
+func printBucketDomains(domains []qiniuapi.BucketDomain) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tBUCKET\tCERT ID\tUPDATED")
+	for _, domain := range domains {
+		certID := domain.CertID
+		if certID == "" {
+			certID = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", domain.Domain, domain.Bucket, certID,
+			time.Unix(domain.UpdateTime, 0).Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+}
+
+// printPiliDomains prints the domains of live-streaming hubs as a table, in the order of hubs
+func printPiliDomains(hubs []string, domains map[string][]qiniuapi.PiliDomain) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tHUB\tTYPE\tSTATE\tCERT ID\tCNAME")
+	for _, hub := range hubs {
+		for _, domain := range domains[hub] {
+			state := "enabled"
+			if domain.Disable {
+				state = "disabled"
+			}
+			certID := domain.CertID
+			if !domain.CertEnable || certID == "" {
+				certID = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", domain.Domain, hub, domain.Type, state, certID, domain.CNAME)
+		}
+	}
+	w.Flush()
+}
+
+// printDomain prints the configuration of a domain
+func printDomain(domain *qiniuapi.DomainInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", domain.Name)
+	fmt.Fprintf(w, "Type:\t%s\n", domain.Type)
+	if domain.IsPan() {
+		fmt.Fprintf(w, "Wildcard domain:\t%s\n", domain.ParentDomain)
+	}
+	fmt.Fprintf(w, "CNAME:\t%s\n", domain.CNAME)
+	fmt.Fprintf(w, "Platform:\t%s\n", domain.Platform)
+	fmt.Fprintf(w, "Coverage:\t%s\n", domain.GeoCover)
+	fmt.Fprintf(w, "Protocol:\t%s\n", domain.Protocol)
+	fmt.Fprintf(w, "State:\t%s %s\n", domain.OperatingState, domain.OperatingStateDesc)
+	fmt.Fprintf(w, "Last operation:\t%s\n", domain.OperationType)
+	fmt.Fprintf(w, "Account frozen:\t%t\n", domain.UIDIsFreezed)
+	fmt.Fprintf(w, "Created:\t%s\n", formatDomainTime(domain.CreatedAt(), domain.CreateAt))
+	fmt.Fprintf(w, "Modified:\t%s\n", formatDomainTime(domain.ModifiedAt(), domain.ModifyAt))
+	if domain.HTTPS != nil {
+		fmt.Fprintf(w, "Certificate:\t%s\n", domain.HTTPS.CertID)
+		fmt.Fprintf(w, "Force HTTPS:\t%t\n", domain.HTTPS.ForceHttps)
+		fmt.Fprintf(w, "HTTP/2:\t%t\n", domain.HTTPS.Http2Enable)
+	}
+	if source := domain.Source; source != nil {
+		origin := source.SourceDomain
+		switch {
+		case source.SourceQiniuBucket != "":
+			origin = source.SourceQiniuBucket
+		case len(source.SourceIPs) > 0:
+			origin = strings.Join(source.SourceIPs, ", ")
+		case len(source.AdvancedSources) > 0:
+			var addrs []string
+			for _, advanced := range source.AdvancedSources {
+				addrs = append(addrs, advanced.Addr)
+			}
+			origin = strings.Join(addrs, ", ")
+		}
+		fmt.Fprintf(w, "Origin:\t%s %s\n", source.SourceType, origin)
+		fmt.Fprintf(w, "Origin host:\t%s\n", source.SourceHost)
+		fmt.Fprintf(w, "Origin scheme:\t%s\n", source.SourceURLScheme)
+	}
+	if domain.Cache != nil {
+		fmt.Fprintf(w, "Cache rules:\t%d\n", len(domain.Cache.CacheControls))
+	}
+	if domain.Referer != nil && domain.Referer.RefererType != "" {
+		fmt.Fprintf(w, "Referer ACL:\t%s %s\n", domain.Referer.RefererType, strings.Join(domain.Referer.RefererValues, ", "))
+	}
+	if domain.IPACL != nil && domain.IPACL.IPACLType != "" {
+		fmt.Fprintf(w, "IP ACL:\t%s %s\n", domain.IPACL.IPACLType, strings.Join(domain.IPACL.IPACLValues, ", "))
+	}
+	w.Flush()
+}
+
+// formatDomainTime formats a time of the domain API, falling back to the raw value
+// if it could not be parsed
+func formatDomainTime(t time.Time, raw string) string {
+	if t.IsZero() {
+		return raw
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// matchAny checks whether name matches any of the glob patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contains checks whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,7 +12,9 @@ import (
 	"time"
 
 	"github.com/WqyJh/qiniu-ssl/internal/action"
+	"github.com/WqyJh/qiniu-ssl/internal/aliyundns"
 	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/WqyJh/qiniu-ssl/internal/verify"
 	"github.com/urfave/cli/v2"
 )
 
@@ -18,7 +22,7 @@ func main() {
 	app := &cli.App{
 		Name:  "qiniu-ssl",
 		Usage: "Apply for Let's Encrypt SSL certificates using Aliyun DNS challenge and upload to Qiniu CDN",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "qiniu-access-key",
 				Aliases: []string{"qak"},
@@ -31,6 +35,24 @@ func main() {
 				Usage:   "Qiniu secret key",
 				EnvVars: []string{"QINIU_SECRET_KEY"},
 			},
+			&cli.StringFlag{
+				Name:    "qiniu-api-host",
+				Usage:   "Qiniu API base URL",
+				Value:   qiniuapi.QiniuAPIHost,
+				EnvVars: []string{"QINIU_API_HOST"},
+			},
+			&cli.StringFlag{
+				Name:    "qiniu-uc-host",
+				Usage:   "Qiniu bucket management API base URL, used for Kodo bucket domains",
+				Value:   qiniuapi.QiniuUCHost,
+				EnvVars: []string{"QINIU_UC_HOST"},
+			},
+			&cli.StringFlag{
+				Name:    "qiniu-pili-host",
+				Usage:   "Qiniu live-streaming API base URL, used for live-streaming domains",
+				Value:   qiniuapi.QiniuPiliHost,
+				EnvVars: []string{"QINIU_PILI_HOST"},
+			},
 			&cli.StringFlag{
 				Name:    "aliyun-access-key",
 				Aliases: []string{"aak"},
@@ -50,6 +72,23 @@ func main() {
 				Value:   "cn-hangzhou",
 				EnvVars: []string{"ALIYUN_REGION"},
 			},
+			&cli.StringFlag{
+				Name:    "aliyun-endpoint",
+				Usage:   "Aliyun DNS API endpoint, e.g. " + aliyundns.IntlEndpoint + " for Alibaba Cloud international",
+				EnvVars: []string{"ALIYUN_ENDPOINT"},
+			},
+			&cli.Int64Flag{
+				Name:    "aliyun-dns-ttl",
+				Usage:   "TTL in seconds of the DNS challenge records, raised to the zone's minimum TTL if lower",
+				Value:   aliyundns.DefaultTTL,
+				EnvVars: []string{"ALIYUN_DNS_TTL"},
+			},
+			&cli.StringFlag{
+				Name:    "aliyun-dns-line",
+				Usage:   "Resolution line of the DNS challenge records",
+				Value:   aliyundns.DefaultLine,
+				EnvVars: []string{"ALIYUN_DNS_LINE"},
+			},
 			&cli.StringFlag{
 				Name:    "domain",
 				Aliases: []string{"d"},
@@ -80,6 +119,11 @@ func main() {
 				Usage:   "Enable HTTP/2 for the domain",
 				Value:   true,
 			},
+			&cli.BoolFlag{
+				Name:  "preserve-https",
+				Usage: "Keep the current force HTTPS and HTTP/2 settings of domains when replacing their certificate, unless --force-https or --http2 is given",
+				Value: true,
+			},
 			&cli.IntFlag{
 				Name:    "check-interval",
 				Aliases: []string{"i"},
@@ -108,23 +152,98 @@ func main() {
 				Usage:   "Path to file containing list of domains to check (one domain per line)",
 				Value:   nil,
 			},
+			&cli.BoolFlag{
+				Name:  "manual-dns",
+				Usage: "Create the DNS challenge TXT records manually instead of using Aliyun DNS",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "manual-dns-poll",
+				Usage: "In manual DNS mode, poll DNS for the TXT record instead of waiting for confirmation",
+				Value: false,
+			},
+			&cli.DurationFlag{
+				Name:  "manual-dns-timeout",
+				Usage: "In manual DNS mode, how long to wait for the TXT record to propagate",
+				Value: 10 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:  "wait-timeout",
+				Usage: "How long to wait for Qiniu to finish applying domain changes (0 to not wait)",
+				Value: 15 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:  "verify-timeout",
+				Usage: "How long to wait for the CDN edge to serve a new certificate, verified by TLS handshake (0 to not verify)",
+				Value: 0,
+			},
+			&cli.StringFlag{
+				Name:  "verify-target",
+				Usage: "Edge host name or IP address, with optional port, to verify against instead of the domain itself, or \"cname\" for the domain's CNAME",
+				Value: "",
+			},
+			&cli.BoolFlag{
+				Name:  "rollback",
+				Usage: "Bind the previous certificate again if Qiniu fails to apply a new one or verification fails",
+				Value: true,
+			},
+			&cli.BoolFlag{
+				Name:  "provision-caa",
+				Usage: "Add a CAA record authorizing Let's Encrypt if the existing CAA records do not",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "gc-certs",
+				Usage: "Delete certificates uploaded by this tool once they are superseded and no longer bound to a domain",
+				Value: false,
+			},
+			&cli.DurationFlag{
+				Name:  "gc-grace-period",
+				Usage: "How long to keep superseded certificates before deleting them",
+				Value: 72 * time.Hour,
+			},
+			&cli.BoolFlag{
+				Name:  "sweep-challenges",
+				Usage: "In daemon mode, delete stale _acme-challenge TXT records on startup",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "check-origins",
+				Usage: "Probe the certificates of the HTTPS origins of CDN domains on every check and report the ones expiring or invalid",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the domains that would be checked and exit",
+				Value: false,
+			},
+		}, discoverFlags...),
+		Commands: []*cli.Command{
+			dnsCommand(),
+			certsCommand(),
+			domainsCommand(),
+			rollbackCommand(),
+			deployCommand(),
 		},
 		Action: func(c *cli.Context) error {
 			qiniuAccessKey := c.String("qiniu-access-key")
 			qiniuSecretKey := c.String("qiniu-secret-key")
 			aliyunAccessKey := c.String("aliyun-access-key")
 			aliyunSecretKey := c.String("aliyun-secret-key")
-			aliyunRegion := c.String("aliyun-region")
 			domain := c.String("domain")
-			email := c.String("email")
 			certDir := c.String("cert-dir")
-			forceHTTPS := c.Bool("force-https")
-			http2 := c.Bool("http2")
 			checkInterval := c.Int("check-interval")
 			threshold := c.Int("threshold")
 			daemon := c.Bool("daemon")
 			logFile := c.String("log-file")
 			domainsFiles := c.StringSlice("domains-file")
+			manualDNS := c.Bool("manual-dns")
+			sweepChallenges := c.Bool("sweep-challenges")
+			gcCerts := c.Bool("gc-certs")
+			gcGracePeriod := c.Duration("gc-grace-period")
+			discover := c.Bool("discover")
+			dryRun := c.Bool("dry-run")
+			checkOrigins := c.Bool("check-origins")
 
 			// Configure logging
 			if logFile != "" {
@@ -138,6 +257,7 @@ func main() {
 
 			// Get domains from file if specified
 			domains := []string{}
+			domainConfigs := make(map[string]domainConfig)
 			if domain != "" {
 				domains = append(domains, domain)
 			}
@@ -151,13 +271,26 @@ func main() {
 				lines := splitLines(string(content))
 				for _, line := range lines {
 					if line != "" {
-						domains = append(domains, line)
+						config, err := parseDomainLine(line)
+						if err != nil {
+							return fmt.Errorf("invalid domains file %s: %v", domainsFile, err)
+						}
+						domains = append(domains, config.Name)
+						domainConfigs[config.Name] = config
 					}
 				}
 			}
 
-			if len(domains) == 0 {
-				return fmt.Errorf("no domains specified, use --domain or --domains-file")
+			if len(domains) == 0 && !discover {
+				return fmt.Errorf("no domains specified, use --domain, --domains-file or --discover")
+			}
+
+			var filter *domainFilter
+			if discover {
+				var err error
+				if filter, err = newDomainFilter(c); err != nil {
+					return err
+				}
 			}
 
 			// Validate required parameters
@@ -165,10 +298,14 @@ func main() {
 				return fmt.Errorf("qiniu access key and secret key are required")
 			}
 
-			if aliyunAccessKey == "" || aliyunSecretKey == "" {
+			if !manualDNS && !dryRun && (aliyunAccessKey == "" || aliyunSecretKey == "") {
 				return fmt.Errorf("aliyun access key and secret key are required")
 			}
 
+			if manualDNS && daemon {
+				return fmt.Errorf("manual DNS mode cannot be used in daemon mode")
+			}
+
 			// Ensure certificate directory exists
 			if err := os.MkdirAll(certDir, 0700); err != nil {
 				return fmt.Errorf("failed to create certificate directory: %v", err)
@@ -186,59 +323,237 @@ func main() {
 				return fmt.Errorf("check interval must be less than threshold")
 			}
 
-			// Create a channel to handle termination signals
-			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+			// Cancelled on termination signals
+			ctx := c.Context
 
 			// Create Qiniu client for API operations
-			qiniuClient, err := qiniuapi.NewQiniuClient(qiniuAccessKey, qiniuSecretKey)
+			qiniuClient, err := newQiniuClient(c)
 			if err != nil {
-				return fmt.Errorf("failed to create Qiniu client: %v", err)
+				return err
+			}
+
+			// resolveDomains returns the configured domains along with the discovered ones, and
+			// all CDN domains of the account if they were listed to discover them.
+			// Domains are discovered again on every check so that new domains are picked up.
+			resolveDomains := func() ([]string, []qiniuapi.DomainInfo, error) {
+				if !discover {
+					return domains, nil, nil
+				}
+
+				listed, err := qiniuClient.ListAllDomains(ctx)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to discover domains: %w", err)
+				}
+				discovered := discoverDomains(listed, filter)
+				if dryRun {
+					printDomains(discovered)
+				}
+
+				resolved := append([]string{}, domains...)
+				for _, domain := range discovered {
+					if !contains(resolved, domain.Name) {
+						resolved = append(resolved, domain.Name)
+					}
+				}
+				return resolved, listed, nil
+			}
+
+			// Show the domains that would be checked without acting on them
+			if dryRun {
+				resolved, _, err := resolveDomains()
+				if err != nil {
+					return err
+				}
+				log.Printf("Dry run: would check certificates for %d domains: %s", len(resolved), strings.Join(resolved, ", "))
+				return nil
+			}
+
+			// Create DNS provider for the ACME DNS-01 challenge
+			dnsProvider, err := newChallengeProvider(c)
+			if err != nil {
+				return err
+			}
+
+			// Remove challenge records left behind by interrupted runs
+			if aliyunProvider, ok := dnsProvider.(*aliyundns.DNSProvider); ok && daemon && sweepChallenges {
+				log.Printf("Sweeping stale DNS challenge records...")
+				if err := sweepChallengeRecords(aliyunProvider, time.Hour, false, false); err != nil {
+					log.Printf("Failed to sweep stale DNS challenge records: %v", err)
+				}
+			}
+
+			// domainOptions returns the deployment options of a domain, the settings
+			// from the domains file taking precedence over the global flags
+			domainOptions := func(domainName string) action.Options {
+				opts := deployOptions(c, domainName)
+
+				config := domainConfigs[domainName]
+				if config.ForceHTTPS != nil {
+					opts.ForceHTTPS = *config.ForceHTTPS
+					opts.OverrideForceHTTPS = true
+				}
+				if config.HTTP2 != nil {
+					opts.HTTP2 = *config.HTTP2
+					opts.OverrideHTTP2 = true
+				}
+				opts.Target = config.Target
+				opts.Bucket = config.Bucket
+				opts.Hub = config.Hub
+				return opts
 			}
 
 			// Function to check and renew certificates for all domains
 			checkAndRenewAll := func() error {
+				domains, listed, err := resolveDomains()
+				if err != nil {
+					return err
+				}
+
 				timestamp := time.Now().Format("2006-01-02 15:04:05")
 				log.Printf("[%s] Checking certificates for %d domains", timestamp, len(domains))
 
+				// Kodo bucket and live-streaming domains are checked through their own APIs
+				var cdnDomains []string
+				bucketDomains := make(map[string]string)
+				piliDomains := make(map[string]string)
 				for _, domainName := range domains {
-					log.Printf("Processing domain: %s", domainName)
-					// Check certificate directly from Qiniu API
-					needsRenewal, certInfo, err := qiniuClient.CheckCertificateFromQiniu(domainName, threshold)
+					switch config := domainConfigs[domainName]; config.Target {
+					case action.TargetKodo:
+						bucketDomains[domainName] = config.Bucket
+					case action.TargetPili:
+						piliDomains[domainName] = config.Hub
+					default:
+						cdnDomains = append(cdnDomains, domainName)
+					}
+				}
+
+				// Check all certificates directly from Qiniu API at once, reusing the domain list
+				// if the domains were discovered
+				var statuses map[string]*qiniuapi.CertificateStatus
+				if discover {
+					statuses, err = qiniuClient.CheckListedCertificates(ctx, listed, cdnDomains, threshold, qiniuapi.DefaultCheckConcurrency)
+				} else {
+					statuses, err = qiniuClient.CheckCertificates(ctx, cdnDomains, threshold, qiniuapi.DefaultCheckConcurrency)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to check certificates: %w", err)
+				}
+				if len(bucketDomains) > 0 {
+					bucketStatuses, err := qiniuClient.CheckBucketCertificates(ctx, bucketDomains, threshold, qiniuapi.DefaultCheckConcurrency)
+					if err != nil {
+						return fmt.Errorf("failed to check certificates of bucket domains: %w", err)
+					}
+					for domainName, status := range bucketStatuses {
+						statuses[domainName] = status
+					}
+				}
+				if len(piliDomains) > 0 {
+					piliStatuses, err := qiniuClient.CheckPiliCertificates(ctx, piliDomains, threshold, qiniuapi.DefaultCheckConcurrency)
+					if err != nil {
+						return fmt.Errorf("failed to check certificates of live-streaming domains: %w", err)
+					}
+					for domainName, status := range piliStatuses {
+						statuses[domainName] = status
+					}
+				}
+
+				// Origin certificates live on the origin servers, they are only reported
+				if checkOrigins {
+					log.Printf("Checking the HTTPS origins of %d CDN domains...", len(cdnDomains))
+					origins, err := action.CheckOrigins(ctx, qiniuClient, cdnDomains, threshold)
 					if err != nil {
+						return fmt.Errorf("failed to check origins: %w", err)
+					}
+
+					var failing []string
+					for _, origin := range origins {
+						if (origin.Err != nil || origin.Expiring(threshold)) && !contains(failing, origin.Domain) {
+							failing = append(failing, origin.Domain)
+						}
+					}
+					if len(failing) > 0 {
+						log.Printf("Domains with an HTTPS origin certificate to fix: %s", strings.Join(failing, ", "))
+					}
+				}
+
+				// Domains whose new certificate is not served by the CDN edge
+				var unverified []string
+
+				for _, domainName := range domains {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+
+					log.Printf("Processing domain: %s", domainName)
+					status := statuses[domainName]
+					if status.Err != nil && !status.NeedsRenewal {
+						// Disabled, frozen or offline domains cannot be changed
+						log.Printf("Skipping domain %s: %v", domainName, status.Err)
+						continue
+					}
+
+					if status.Err != nil {
 						// If there's an error (like no HTTPS or certificate), assume we need to create one
-						log.Printf("Error checking certificate for %s from Qiniu: %v", domainName, err)
+						log.Printf("Error checking certificate for %s from Qiniu: %v", domainName, status.Err)
 						log.Printf("Will attempt to request new certificate for %s", domainName)
-						needsRenewal = true
-					} else if needsRenewal {
-						expiresAt := time.Unix(certInfo.NotAfter, 0)
+					} else if status.NeedsRenewal {
+						expiresAt := time.Unix(status.Certificate.NotAfter, 0)
 						daysLeft := int(time.Until(expiresAt).Hours() / 24)
 						log.Printf("Certificate for %s is expiring on %s (in %d days), renewing...",
 							domainName, expiresAt.Format("2006-01-02"), daysLeft)
 					} else {
-						expiresAt := time.Unix(certInfo.NotAfter, 0)
+						expiresAt := time.Unix(status.Certificate.NotAfter, 0)
 						daysLeft := int(time.Until(expiresAt).Hours() / 24)
 						log.Printf("Certificate for %s is valid until %s (%d days), no renewal needed",
 							domainName, expiresAt.Format("2006-01-02"), daysLeft)
+
+						// Keep the HTTPS settings in line with the configuration
+						if opts := domainOptions(domainName); opts.HasDesiredHTTPS() {
+							if _, err := action.ReconcileHTTPS(ctx, qiniuClient, opts); err != nil {
+								log.Printf("Failed to reconcile HTTPS settings for %s: %v", domainName, err)
+							}
+						}
 						continue
 					}
 
 					// Request new certificate and update it on Qiniu
 					log.Printf("Requesting and uploading new certificate for %s...", domainName)
-					if err := action.Run(qiniuAccessKey, qiniuSecretKey, aliyunAccessKey, aliyunSecretKey,
-						domainName, email, certDir, aliyunRegion, forceHTTPS, http2); err != nil {
+					if err := action.Run(ctx, qiniuClient, dnsProvider, domainOptions(domainName)); err != nil {
 						log.Printf("Failed to renew certificate for %s: %v", domainName, err)
+						var mismatch *verify.MismatchError
+						var rolledBack *action.RolledBackError
+						if errors.As(err, &rolledBack) {
+							log.Printf("Domain %s has been rolled back to certificate %s", domainName, rolledBack.RestoredCertID)
+						} else if errors.As(err, &mismatch) {
+							unverified = append(unverified, domainName)
+						}
 						continue
 					}
 
 					log.Printf("Certificate for %s has been renewed successfully", domainName)
 				}
 
+				if len(unverified) > 0 {
+					log.Printf("Domains still serving an old certificate: %s", strings.Join(unverified, ", "))
+				}
+
+				// Delete certificates replaced by renewals
+				if gcCerts {
+					log.Printf("Deleting superseded certificates...")
+					if err := action.CollectCertificates(ctx, qiniuClient, certDir, gcGracePeriod, false); err != nil {
+						log.Printf("Failed to delete superseded certificates: %v", err)
+					}
+				}
+
 				return nil
 			}
 
 			// Run once immediately
 			if err := checkAndRenewAll(); err != nil {
+				if ctx.Err() != nil {
+					log.Printf("Received signal, shutting down...")
+					return nil
+				}
 				return fmt.Errorf("error during certificate check: %w", err)
 			}
 
@@ -258,8 +573,8 @@ func main() {
 						if err := checkAndRenewAll(); err != nil {
 							log.Printf("Error during certificate check: %v", err)
 						}
-					case sig := <-sigChan:
-						log.Printf("Received signal %v, shutting down...", sig)
+					case <-ctx.Done():
+						log.Printf("Received signal, shutting down...")
 						return nil
 					}
 				}
@@ -269,11 +584,34 @@ func main() {
 		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	// Every command runs with a context that is cancelled on termination signals
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := app.RunContext(ctx, os.Args)
+	stop()
+	if err != nil {
 		log.Fatalf("Error: %v", err)
 	}
 }
 
+// deployOptions returns the deployment options of a domain from the global flags
+func deployOptions(c *cli.Context, domain string) action.Options {
+	return action.Options{
+		Domain:             domain,
+		Email:              c.String("email"),
+		CertDir:            c.String("cert-dir"),
+		ForceHTTPS:         c.Bool("force-https"),
+		HTTP2:              c.Bool("http2"),
+		ProvisionCAA:       c.Bool("provision-caa"),
+		PreserveHTTPS:      c.Bool("preserve-https"),
+		OverrideForceHTTPS: c.IsSet("force-https"),
+		OverrideHTTP2:      c.IsSet("http2"),
+		WaitTimeout:        c.Duration("wait-timeout"),
+		VerifyTimeout:      c.Duration("verify-timeout"),
+		VerifyTarget:       c.String("verify-target"),
+		Rollback:           c.Bool("rollback"),
+	}
+}
+
 // splitLines splits a string into lines
 func splitLines(s string) []string {
 	var lines []string
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/WqyJh/qiniu-ssl/internal/action"
+	"github.com/urfave/cli/v2"
+)
+
+// rollbackCommand returns the "rollback" command
+func rollbackCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "rollback",
+		Usage:     "Bind the certificate a domain had before the last deployment again",
+		ArgsUsage: "<domain>",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected exactly one domain")
+			}
+
+			qiniuClient, err := newQiniuClient(c)
+			if err != nil {
+				return err
+			}
+
+			return action.Rollback(c.Context, qiniuClient, c.String("cert-dir"), c.Args().First(), c.Duration("wait-timeout"))
+		},
+	}
+}
@@ -5,6 +5,7 @@ go 1.23.6
 require (
 	github.com/aliyun/alibaba-cloud-sdk-go v1.63.102
 	github.com/go-acme/lego/v4 v4.22.2
+	github.com/miekg/dns v1.1.62
 	github.com/qiniu/go-sdk/v7 v7.25.2
 	github.com/urfave/cli/v2 v2.27.6
 )
@@ -16,7 +17,6 @@ require (
 	github.com/go-jose/go-jose/v4 v4.0.4 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
-	github.com/miekg/dns v1.1.62 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/opentracing/opentracing-go v1.2.1-0.20220228012449-10b1cf09e00b // indirect
@@ -1,39 +1,108 @@
 package action
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/WqyJh/qiniu-ssl/internal/certcheck"
 	"github.com/WqyJh/qiniu-ssl/internal/certmanager"
 	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/WqyJh/qiniu-ssl/internal/verify"
+	"github.com/go-acme/lego/v4/challenge"
 )
 
-func Run(
-	qiniuAccessKey, qiniuSecretKey, aliyunAccessKey, aliyunSecretKey, domain, email, certDir, aliyunRegion string,
-	forceHTTPS, http2 bool,
-) error {
+// waitInterval is the interval between checks while waiting for a domain operation,
+// shortened by tests
+var waitInterval = 10 * time.Second
+
+// Options holds the settings of a certificate deployment
+type Options struct {
+	Domain       string
+	Email        string
+	CertDir      string
+	ForceHTTPS   bool
+	HTTP2        bool
+	ProvisionCAA bool
+
+	// PreserveHTTPS keeps the current force HTTPS and HTTP/2 settings of domains that
+	// already have HTTPS enabled, only replacing the certificate. ForceHTTPS and HTTP2
+	// still apply if OverrideForceHTTPS or OverrideHTTP2 is set.
+	PreserveHTTPS      bool
+	OverrideForceHTTPS bool
+	OverrideHTTP2      bool
+
+	// WaitTimeout is how long to wait for Qiniu to finish domain operations,
+	// 0 to not wait
+	WaitTimeout time.Duration
+
+	// VerifyTimeout is how long to wait for the CDN edge to serve the new certificate,
+	// 0 to not verify. VerifyTarget is the edge host name or IP address to check, with
+	// an optional port; it defaults to the domain itself, and "cname" means the CNAME
+	// of the domain.
+	VerifyTimeout time.Duration
+	VerifyTarget  string
+
+	// Rollback binds the previous certificate again if Qiniu fails to apply the new one
+	// or the CDN edge does not serve it
+	Rollback bool
+
+	// Target is the kind of domain the certificate is deployed to, TargetCDN if empty.
+	// Bucket is the Kodo bucket the domain is bound to, for TargetKodo, and Hub the
+	// live-streaming hub of the domain, for TargetPili.
+	Target string
+	Bucket string
+	Hub    string
+}
+
+// VerifyTargetCNAME is the VerifyTarget selecting the CNAME of the domain
+const VerifyTargetCNAME = "cname"
+
+// wildcardVerifyLabel is the subdomain of a wildcard domain used for SNI when verifying it
+const wildcardVerifyLabel = "qiniu-ssl-verify"
+
+// Deployment targets
+const (
+	TargetCDN  = "cdn"  // Qiniu CDN domain
+	TargetKodo = "kodo" // Custom domain of a Kodo bucket
+	TargetPili = "pili" // Play or push domain of a live-streaming hub
+)
+
+// Run requests a certificate for the domain, solving the DNS-01 challenge with dnsProvider,
+// uploads it to Qiniu and binds it to the CDN domain
+func Run(ctx context.Context, qiniu *qiniuapi.QiniuClient, dnsProvider challenge.Provider, opts Options) error {
+	domain := opts.Domain
+
 	// Validate required parameters
-	if qiniuAccessKey == "" || qiniuSecretKey == "" {
-		return fmt.Errorf("qiniu access key and secret key are required")
+	if qiniu == nil {
+		return fmt.Errorf("qiniu client is required")
 	}
 
-	if aliyunAccessKey == "" || aliyunSecretKey == "" {
-		return fmt.Errorf("aliyun access key and secret key are required")
+	if dnsProvider == nil {
+		return fmt.Errorf("DNS provider is required")
 	}
 
 	if domain == "" {
 		return fmt.Errorf("domain name is required")
 	}
 
+	// Check the domain first, so that a missing domain fails before requesting a certificate
+	deployTo, err := prepareDeploy(ctx, qiniu, opts)
+	if err != nil {
+		return err
+	}
+
 	// Create certificate manager
-	cm, err := certmanager.NewCertManager(domain, email, certDir)
+	cm, err := certmanager.NewCertManager(domain, opts.Email, opts.CertDir)
 	if err != nil {
 		return fmt.Errorf("failed to create certificate manager: %v", err)
 	}
 
-	// Request certificate using Aliyun DNS challenge
-	log.Printf("Requesting certificate for %s using Aliyun DNS challenge...", domain)
-	if err := cm.RequestCertificate(aliyunAccessKey, aliyunSecretKey, aliyunRegion); err != nil {
+	// Request certificate using DNS challenge
+	log.Printf("Requesting certificate for %s using DNS challenge...", domain)
+	if err := cm.RequestCertificate(dnsProvider, opts.ProvisionCAA); err != nil {
 		return fmt.Errorf("failed to request certificate: %v", err)
 	}
 	log.Printf("Certificate for %s has been obtained successfully", domain)
@@ -49,27 +118,157 @@ func Run(
 		return fmt.Errorf("failed to load certificate: %v", err)
 	}
 
-	// Create Qiniu client
-	qiniu, err := qiniuapi.NewQiniuClient(qiniuAccessKey, qiniuSecretKey)
-	if err != nil {
-		return fmt.Errorf("failed to create Qiniu client: %v", err)
+	if err := deployTo(certPEM, keyPEM); err != nil {
+		return err
+	}
+
+	log.Printf("All operations completed successfully!")
+	return nil
+}
+
+// Deploy uploads an existing certificate to Qiniu and binds it to the domain
+func Deploy(ctx context.Context, qiniu *qiniuapi.QiniuClient, certPEM, keyPEM []byte, opts Options) error {
+	if qiniu == nil {
+		return fmt.Errorf("qiniu client is required")
+	}
+
+	if opts.Domain == "" {
+		return fmt.Errorf("domain name is required")
 	}
 
-	// Upload certificate to Qiniu
-	log.Printf("Uploading certificate to Qiniu...")
-	certID, err := qiniu.UploadCertificate(domain, certPEM, keyPEM)
+	deployTo, err := prepareDeploy(ctx, qiniu, opts)
 	if err != nil {
-		return fmt.Errorf("failed to upload certificate: %v", err)
+		return err
+	}
+
+	if err := deployTo(certPEM, keyPEM); err != nil {
+		return err
 	}
-	log.Printf("Certificate has been uploaded to Qiniu with ID: %s", certID)
 
-	// Get domain information to check if HTTPS is supported
+	log.Printf("Certificate has been deployed to %s successfully", opts.Domain)
+	return nil
+}
+
+// prepareDeploy checks that the domain targeted by opts exists and its certificate can be
+// changed, and returns the function deploying a certificate to it
+func prepareDeploy(ctx context.Context, qiniu *qiniuapi.QiniuClient, opts Options) (func(certPEM, keyPEM []byte) error, error) {
+	switch opts.Target {
+	case "", TargetCDN:
+		domainInfo, err := deployableDomain(ctx, qiniu, opts.Domain)
+		if err != nil {
+			return nil, err
+		}
+		return func(certPEM, keyPEM []byte) error {
+			return deploy(ctx, qiniu, domainInfo, certPEM, keyPEM, opts)
+		}, nil
+	case TargetKodo:
+		bucketDomain, err := deployableBucketDomain(ctx, qiniu, opts.Bucket, opts.Domain)
+		if err != nil {
+			return nil, err
+		}
+		return func(certPEM, keyPEM []byte) error {
+			return deployToBucket(ctx, qiniu, bucketDomain, certPEM, keyPEM, opts)
+		}, nil
+	case TargetPili:
+		piliDomain, err := deployablePiliDomain(ctx, qiniu, opts.Hub, opts.Domain)
+		if err != nil {
+			return nil, err
+		}
+		return func(certPEM, keyPEM []byte) error {
+			return deployToPili(ctx, qiniu, piliDomain, certPEM, keyPEM, opts)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown deployment target %q for domain %s", opts.Target, opts.Domain)
+	}
+}
+
+// deployableDomain retrieves the domain information, failing if the domain does not exist
+// or its certificate cannot be changed
+func deployableDomain(ctx context.Context, qiniu *qiniuapi.QiniuClient, domain string) (*qiniuapi.DomainInfo, error) {
 	log.Printf("Retrieving domain information for %s...", domain)
-	domainInfo, err := qiniu.GetDomainInfo(domain)
+	domainInfo, err := qiniu.GetDomainInfo(ctx, domain)
+	if err != nil {
+		if qiniuapi.IsNotFound(err) {
+			return nil, fmt.Errorf("domain %s not found in Qiniu, add it in the Qiniu console or with \"domains create\" first: %w", domain, err)
+		}
+		return nil, fmt.Errorf("failed to retrieve domain information: %w", err)
+	}
+
+	if domainInfo.IsDisabled() {
+		return nil, fmt.Errorf("domain %s is %s in Qiniu, its certificate cannot be changed", domain, domainState(domainInfo))
+	}
+
+	return domainInfo, nil
+}
+
+// deploy uploads the certificate, binds it to the domain, waits for Qiniu to apply it
+// and verifies it, rolling back if that fails
+func deploy(ctx context.Context, qiniu *qiniuapi.QiniuClient, domainInfo *qiniuapi.DomainInfo, certPEM, keyPEM []byte, opts Options) error {
+	domain := opts.Domain
+
+	// Catch problems locally, Qiniu's rejection messages are vague
+	if err := certcheck.Validate(certPEM, keyPEM, domain); err != nil {
+		return fmt.Errorf("certificate cannot be deployed to %s: %w", domain, err)
+	}
+
+	// A previous operation on the domain must finish before the HTTPS configuration can change
+	var err error
+	if domainInfo.IsProcessing() {
+		if domainInfo, err = waitForDomain(ctx, qiniu, domain, opts.WaitTimeout); err != nil {
+			return err
+		}
+	}
+
+	// Remember the current binding so that it can be restored
+	var previous *deployment
+	if https := domainInfo.HTTPS; https != nil && https.CertID != "" {
+		previous = &deployment{
+			PreviousCertID:     https.CertID,
+			PreviousForceHTTPS: https.ForceHttps,
+			PreviousHTTP2:      https.Http2Enable,
+		}
+	}
+
+	// Upload certificate to Qiniu, unless an identical one is already there, and bind it
+	certID, err := uploadAndBind(ctx, qiniu, certPEM, keyPEM, opts, func(certID string) error {
+		return bindCertificate(ctx, qiniu, domainInfo, certID, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	if previous != nil && previous.PreviousCertID != certID {
+		previous.CertID = certID
+		previous.DeployedAt = time.Now()
+		if err := recordDeployment(opts.CertDir, domain, previous); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	} else {
+		// Nothing to roll back to
+		previous = nil
+		if err := forgetStaleDeployment(opts.CertDir, domain, certID); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	// Qiniu applies the change asynchronously, wait for it to finish
+	_, err = waitForDomain(ctx, qiniu, domain, opts.WaitTimeout)
+	if err == nil {
+		// Make sure the CDN edge actually serves the new certificate
+		err = verifyDeployment(ctx, domainInfo.CNAME, certPEM, opts)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to retrieve domain information: %v", err)
+		return rollbackDeployment(ctx, qiniu, previous, err, opts)
 	}
 
+	return nil
+}
+
+// bindCertificate enables HTTPS on the domain with the certificate, or replaces
+// the certificate if HTTPS is already enabled
+func bindCertificate(ctx context.Context, qiniu *qiniuapi.QiniuClient, domainInfo *qiniuapi.DomainInfo, certID string, opts Options) error {
+	domain := opts.Domain
+
 	// Check if HTTPS is supported - use the HTTPS field instead of Protocol
 	httpsSupported := domainInfo.HTTPS != nil && domainInfo.HTTPS.CertID != ""
 
@@ -77,20 +276,110 @@ func Run(
 	if !httpsSupported {
 		log.Printf("Domain %s does not support HTTPS, enabling it now...", domain)
 		// 调用 sslize 接口启用 HTTPS，并直接绑定证书
-		if err := qiniu.EnableHTTPS(domain, certID, forceHTTPS, http2); err != nil {
-			return fmt.Errorf("failed to enable HTTPS for domain: %v", err)
+		if err := qiniu.EnableHTTPS(ctx, domain, certID, opts.ForceHTTPS, opts.HTTP2); err != nil {
+			if qiniuapi.IsDomainProcessing(err) {
+				return fmt.Errorf("domain %s is being processed by Qiniu, try again later: %w", domain, err)
+			}
+			return fmt.Errorf("failed to enable HTTPS for domain: %w", err)
 		}
 		log.Printf("HTTPS has been enabled for domain %s with certificate ID %s", domain, certID)
 	} else {
 		log.Printf("Domain %s already supports HTTPS, updating certificate...", domain)
 		// Update HTTPS configuration with the new certificate
-		log.Printf("Updating HTTPS configuration for domain %s...", domain)
-		if err := qiniu.UpdateHTTPSConfig(domain, certID, forceHTTPS, http2); err != nil {
-			return fmt.Errorf("failed to update HTTPS configuration: %v", err)
+		forceHTTPS, http2 := opts.httpsSettings(domainInfo.HTTPS)
+		log.Printf("Updating HTTPS configuration for domain %s (force HTTPS: %t, HTTP/2: %t)...", domain, forceHTTPS, http2)
+		if err := qiniu.UpdateHTTPSConfig(ctx, domain, certID, forceHTTPS, http2); err != nil {
+			if qiniuapi.IsDomainProcessing(err) {
+				return fmt.Errorf("domain %s is being processed by Qiniu, try again later: %w", domain, err)
+			}
+			return fmt.Errorf("failed to update HTTPS configuration: %w", err)
 		}
 		log.Printf("HTTPS configuration has been updated for domain %s successfully", domain)
 	}
 
-	log.Printf("All operations completed successfully!")
 	return nil
 }
+
+// httpsSettings returns the force HTTPS and HTTP/2 settings to apply to a domain
+// with the current HTTPS configuration
+func (opts Options) httpsSettings(current *qiniuapi.HTTPSInfo) (forceHTTPS, http2 bool) {
+	forceHTTPS, http2 = opts.ForceHTTPS, opts.HTTP2
+	if !opts.PreserveHTTPS || current == nil {
+		return forceHTTPS, http2
+	}
+
+	if !opts.OverrideForceHTTPS {
+		forceHTTPS = current.ForceHttps
+	}
+	if !opts.OverrideHTTP2 {
+		http2 = current.Http2Enable
+	}
+	return forceHTTPS, http2
+}
+
+// verifyDeployment checks that the CDN edge serves the certificate by TLS handshake,
+// waiting at most opts.VerifyTimeout for it to propagate. CNAME is the CNAME of the domain,
+// if any.
+func verifyDeployment(ctx context.Context, cname string, certPEM []byte, opts Options) error {
+	if opts.VerifyTimeout <= 0 {
+		return nil
+	}
+
+	marker, err := qiniuapi.NewCertificateMarker(certPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	target := opts.VerifyTarget
+	if target == VerifyTargetCNAME {
+		target = cname
+	}
+
+	// A wildcard domain .example.com is not a host name, the edge serves its certificate
+	// for any subdomain
+	serverName := opts.Domain
+	if strings.HasPrefix(serverName, ".") {
+		if target == "" {
+			log.Printf("Skipping verification of wildcard domain %s, set a verify target to check it", opts.Domain)
+			return nil
+		}
+		serverName = wildcardVerifyLabel + serverName
+	}
+
+	log.Printf("Verifying that the CDN edge serves the new certificate for %s...", serverName)
+	if err := verify.WaitForCertificate(ctx, serverName, target, marker.Fingerprint, opts.VerifyTimeout, waitInterval); err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	log.Printf("CDN edge is serving the new certificate for %s", serverName)
+
+	return nil
+}
+
+// domainState describes the operating state of a domain
+func domainState(domainInfo *qiniuapi.DomainInfo) string {
+	if domainInfo.UIDIsFreezed {
+		return "frozen (account frozen)"
+	}
+	return domainInfo.OperatingState
+}
+
+// waitForDomain waits for the current operation on a domain to finish and reports its final state.
+// It only checks the current state if timeout is 0.
+func waitForDomain(ctx context.Context, qiniu *qiniuapi.QiniuClient, domain string, timeout time.Duration) (*qiniuapi.DomainInfo, error) {
+	if timeout <= 0 {
+		domainInfo, err := qiniu.GetDomainInfo(ctx, domain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve domain information: %w", err)
+		}
+		return domainInfo, nil
+	}
+
+	log.Printf("Waiting up to %s for Qiniu to finish processing domain %s...", timeout, domain)
+	domainInfo, err := qiniu.WaitForDomainOperation(ctx, domain, timeout, waitInterval)
+	if err != nil {
+		return nil, fmt.Errorf("domain %s: %w", domain, err)
+	}
+	log.Printf("Domain %s operation %s finished with state %s", domain, domainInfo.OperationType, domainInfo.OperatingState)
+
+	return domainInfo, nil
+}
@@ -0,0 +1,430 @@
+package action
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/certcache"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi/qiniufake"
+)
+
+// newTestClient starts a fake Qiniu server and returns a client using it for the CDN,
+// Kodo and live-streaming APIs
+func newTestClient(t *testing.T) (*qiniufake.Server, *qiniuapi.QiniuClient) {
+	t.Helper()
+
+	server := qiniufake.NewServer()
+	t.Cleanup(server.Close)
+
+	qiniu, err := qiniuapi.NewQiniuClient("ak", "sk",
+		qiniuapi.WithBaseURL(server.URL),
+		qiniuapi.WithUCURL(server.URL),
+		qiniuapi.WithPiliURL(server.URL))
+	if err != nil {
+		t.Fatalf("NewQiniuClient: %v", err)
+	}
+
+	// Poll the fake server quickly while waiting for domain operations
+	interval := waitInterval
+	waitInterval = 10 * time.Millisecond
+	t.Cleanup(func() { waitInterval = interval })
+
+	return server, qiniu
+}
+
+// newTestCertificate returns a currently valid certificate for the names, issued by a
+// self-signed CA included in the chain
+func newTestCertificate(t *testing.T, names ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	caKey, caDER := newTestKeyPair(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+
+	key, der := newTestKeyPair(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: names[0]},
+		DNSNames:    names,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// newTestKeyPair generates a key and a certificate from template, valid for 90 days and
+// signed by parent, or self-signed if parent is nil
+func newTestKeyPair(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		t.Fatalf("serial: %v", err)
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().AddDate(0, 0, 90)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+
+	return key, der
+}
+
+// boundCertificate returns the certificate bound to a CDN domain of the fake server
+func boundCertificate(t *testing.T, server *qiniufake.Server, domain string) string {
+	t.Helper()
+
+	info, ok := server.Domain(domain)
+	if !ok {
+		t.Fatalf("domain %s not found", domain)
+	}
+	if info.HTTPS == nil {
+		return ""
+	}
+	return info.HTTPS.CertID
+}
+
+func TestDeployEnablesHTTPS(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com"})
+	certPEM, keyPEM := newTestCertificate(t, "cdn.example.com")
+
+	opts := Options{
+		Domain:      "cdn.example.com",
+		CertDir:     t.TempDir(),
+		ForceHTTPS:  true,
+		HTTP2:       true,
+		WaitTimeout: time.Minute,
+	}
+	if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+
+	info, _ := server.Domain("cdn.example.com")
+	if info.Protocol != "https" || info.OperationType != "sslize" {
+		t.Errorf("protocol %s after operation %s, want https after sslize", info.Protocol, info.OperationType)
+	}
+	if info.HTTPS == nil || info.HTTPS.CertID == "" || !info.HTTPS.ForceHttps || !info.HTTPS.Http2Enable {
+		t.Errorf("HTTPS configuration %+v, want a certificate with force HTTPS and HTTP/2", info.HTTPS)
+	}
+
+	history, err := loadHistory(opts.CertDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := history["cdn.example.com"]; ok {
+		t.Errorf("deployment recorded without a previous certificate to roll back to")
+	}
+}
+
+func TestDeployUpdatesHTTPSConfig(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com"})
+	opts := Options{Domain: "cdn.example.com", CertDir: t.TempDir(), WaitTimeout: time.Minute}
+
+	oldCertPEM, oldKeyPEM := newTestCertificate(t, "cdn.example.com")
+	if err := Deploy(context.Background(), qiniu, oldCertPEM, oldKeyPEM, opts); err != nil {
+		t.Fatalf("first Deploy: %v", err)
+	}
+	oldCertID := boundCertificate(t, server, "cdn.example.com")
+
+	certPEM, keyPEM := newTestCertificate(t, "cdn.example.com")
+	opts.HTTP2 = true
+	if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); err != nil {
+		t.Fatalf("second Deploy: %v", err)
+	}
+
+	info, _ := server.Domain("cdn.example.com")
+	if info.OperationType != "modify_https_conf" {
+		t.Errorf("last operation %s, want modify_https_conf", info.OperationType)
+	}
+	certID := boundCertificate(t, server, "cdn.example.com")
+	if certID == oldCertID || !info.HTTPS.Http2Enable {
+		t.Errorf("HTTPS configuration %+v, want a new certificate with HTTP/2", info.HTTPS)
+	}
+
+	history, err := loadHistory(opts.CertDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := history["cdn.example.com"]; d.CertID != certID || d.PreviousCertID != oldCertID {
+		t.Errorf("history %+v, want deployment of %s replacing %s", d, certID, oldCertID)
+	}
+}
+
+func TestDeployWaitsForProcessingDomain(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com"})
+	server.SetProcessingDuration(200 * time.Millisecond)
+
+	// Without waiting, the domain is left processing the first deployment
+	opts := Options{Domain: "cdn.example.com", CertDir: t.TempDir()}
+	oldCertPEM, oldKeyPEM := newTestCertificate(t, "cdn.example.com")
+	if err := Deploy(context.Background(), qiniu, oldCertPEM, oldKeyPEM, opts); err != nil {
+		t.Fatalf("first Deploy: %v", err)
+	}
+
+	certPEM, keyPEM := newTestCertificate(t, "cdn.example.com")
+	if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); !qiniuapi.IsDomainProcessing(err) {
+		t.Fatalf("Deploy to a processing domain without waiting: got %v, want a domain processing error", err)
+	}
+
+	opts.WaitTimeout = time.Minute
+	if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); err != nil {
+		t.Fatalf("Deploy waiting for the domain: %v", err)
+	}
+
+	info, _ := server.Domain("cdn.example.com")
+	if info.IsProcessing() || info.OperatingState != qiniuapi.OperatingStateSuccess {
+		t.Errorf("domain state %s after deployment, want %s", info.OperatingState, qiniuapi.OperatingStateSuccess)
+	}
+}
+
+func TestDeployRollsBackFailedOperation(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com"})
+	opts := Options{Domain: "cdn.example.com", CertDir: t.TempDir(), WaitTimeout: time.Minute, Rollback: true}
+
+	oldCertPEM, oldKeyPEM := newTestCertificate(t, "cdn.example.com")
+	if err := Deploy(context.Background(), qiniu, oldCertPEM, oldKeyPEM, opts); err != nil {
+		t.Fatalf("first Deploy: %v", err)
+	}
+	oldCertID := boundCertificate(t, server, "cdn.example.com")
+
+	server.FailNextOperation("certificate deployment failed")
+	certPEM, keyPEM := newTestCertificate(t, "cdn.example.com")
+	err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts)
+
+	var rolledBack *RolledBackError
+	if !errors.As(err, &rolledBack) {
+		t.Fatalf("Deploy: got %v, want a *RolledBackError", err)
+	}
+	var operationErr *qiniuapi.DomainOperationError
+	if !errors.As(err, &operationErr) {
+		t.Errorf("Deploy: got %v, want it to wrap the *qiniuapi.DomainOperationError", err)
+	}
+	if rolledBack.RestoredCertID != oldCertID {
+		t.Errorf("rolled back to %s, want %s", rolledBack.RestoredCertID, oldCertID)
+	}
+
+	if certID := boundCertificate(t, server, "cdn.example.com"); certID != oldCertID {
+		t.Errorf("domain bound to %s after rollback, want %s", certID, oldCertID)
+	}
+
+	history, err := loadHistory(opts.CertDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := history["cdn.example.com"]; ok {
+		t.Errorf("deployment still recorded after rollback")
+	}
+}
+
+func TestDeployReusesCachedCertificate(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "a.example.com"})
+	server.AddDomain(qiniuapi.DomainInfo{Name: "b.example.com"})
+	certDir := t.TempDir()
+	certPEM, keyPEM := newTestCertificate(t, "a.example.com", "b.example.com")
+
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		opts := Options{Domain: domain, CertDir: certDir, WaitTimeout: time.Minute}
+		if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); err != nil {
+			t.Fatalf("Deploy to %s: %v", domain, err)
+		}
+	}
+
+	certs, err := qiniu.ListAllCertificates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("%d certificates uploaded, want 1", len(certs))
+	}
+
+	marker, err := qiniuapi.NewCertificateMarker(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := certcache.OpenDir(certDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certID, ok := cache.Get(marker.Fingerprint); !ok || certID != certs[0].ID {
+		t.Errorf("cached certificate %q, want %s", certID, certs[0].ID)
+	}
+
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		if certID := boundCertificate(t, server, domain); certID != certs[0].ID {
+			t.Errorf("%s bound to %s, want %s", domain, certID, certs[0].ID)
+		}
+	}
+}
+
+func TestDeployToBucketAndPiliDomains(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddBucketDomain("assets", "static.example.com")
+	server.AddPiliDomain("live", qiniuapi.PiliDomain{Domain: "live.example.com"})
+	certDir := t.TempDir()
+
+	certPEM, keyPEM := newTestCertificate(t, "static.example.com")
+	opts := Options{Domain: "static.example.com", CertDir: certDir, Target: TargetKodo, Bucket: "assets"}
+	if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); err != nil {
+		t.Fatalf("Deploy to bucket domain: %v", err)
+	}
+	if d, _ := server.BucketDomain("static.example.com"); d.CertID == "" {
+		t.Errorf("no certificate bound to bucket domain")
+	}
+
+	certPEM, keyPEM = newTestCertificate(t, "live.example.com")
+	opts = Options{Domain: "live.example.com", CertDir: certDir, Target: TargetPili, Hub: "live"}
+	if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); err != nil {
+		t.Fatalf("Deploy to live-streaming domain: %v", err)
+	}
+	if d, _ := server.PiliDomain("live", "live.example.com"); !d.CertEnable || d.CertID == "" {
+		t.Errorf("HTTPS not enabled on live-streaming domain: %+v", d)
+	}
+}
+
+func TestDeployReusesCertificateUploadedElsewhere(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com"})
+	certPEM, keyPEM := newTestCertificate(t, "cdn.example.com")
+
+	certID, err := qiniu.UploadCertificate(context.Background(), "cdn.example.com", certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("UploadCertificate: %v", err)
+	}
+
+	opts := Options{Domain: "cdn.example.com", CertDir: t.TempDir(), WaitTimeout: time.Minute}
+	if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+
+	if bound := boundCertificate(t, server, "cdn.example.com"); bound != certID {
+		t.Errorf("domain bound to %s, want the certificate uploaded before %s", bound, certID)
+	}
+}
+
+func TestDeployKeepsCachedCertificateWhenDomainIsMissing(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "a.example.com"})
+	server.AddDomain(qiniuapi.DomainInfo{Name: "b.example.com"})
+	certDir := t.TempDir()
+	certPEM, keyPEM := newTestCertificate(t, "a.example.com", "b.example.com")
+
+	opts := Options{Domain: "a.example.com", CertDir: certDir, WaitTimeout: time.Minute}
+	if err := Deploy(context.Background(), qiniu, certPEM, keyPEM, opts); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+
+	// The domain disappears between the check and the binding of the cached certificate
+	opts.Domain = "b.example.com"
+	deployTo, err := prepareDeploy(context.Background(), qiniu, opts)
+	if err != nil {
+		t.Fatalf("prepareDeploy: %v", err)
+	}
+	server.FailNext(1, http.StatusNotFound, qiniuapi.CodeDomainNotFound, "no such domain")
+	if err := deployTo(certPEM, keyPEM); !qiniuapi.IsNotFound(err) {
+		t.Fatalf("deploy to a missing domain: got %v, want not found", err)
+	}
+
+	certs, err := qiniu.ListAllCertificates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Errorf("%d certificates uploaded, want the cached one only", len(certs))
+	}
+
+	marker, err := qiniuapi.NewCertificateMarker(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := certcache.OpenDir(certDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(marker.Fingerprint); !ok {
+		t.Errorf("certificate forgotten from the cache")
+	}
+}
+
+func TestCreateDomain(t *testing.T) {
+	server, qiniu := newTestClient(t)
+
+	req := qiniuapi.CreateDomainRequest{
+		Type:     qiniuapi.DomainTypeNormal,
+		Platform: "web",
+		GeoCover: "china",
+		Protocol: "http",
+		Source:   &qiniuapi.SourceInfo{SourceType: "domain", SourceDomain: "origin.example.com", TestURLPath: "/index.html"},
+	}
+	opts := Options{Domain: "cdn.example.com", WaitTimeout: time.Minute}
+	if err := CreateDomain(context.Background(), qiniu, nil, req, opts); err != nil {
+		t.Fatalf("CreateDomain: %v", err)
+	}
+
+	info, ok := server.Domain("cdn.example.com")
+	if !ok {
+		t.Fatal("domain was not created")
+	}
+	if info.Source == nil || info.Source.TestURLPath != "/index.html" {
+		t.Errorf("source %+v, want test URL path /index.html", info.Source)
+	}
+}
+
+func TestCreateDomainSkipsExistingDomain(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com", Source: &qiniuapi.SourceInfo{SourceType: "ip", SourceIPs: []string{"192.0.2.1"}}})
+
+	// Rerunning the command after an earlier attempt created the domain must not fail
+	req := qiniuapi.CreateDomainRequest{
+		Type:     qiniuapi.DomainTypeNormal,
+		Protocol: "http",
+		Source:   &qiniuapi.SourceInfo{SourceType: "domain", SourceDomain: "origin.example.com"},
+	}
+	opts := Options{Domain: "cdn.example.com", WaitTimeout: time.Minute}
+	if err := CreateDomain(context.Background(), qiniu, nil, req, opts); err != nil {
+		t.Fatalf("CreateDomain: %v", err)
+	}
+
+	info, _ := server.Domain("cdn.example.com")
+	if info.Source.SourceType != "ip" {
+		t.Errorf("origin type %s, want the existing domain to be kept", info.Source.SourceType)
+	}
+}
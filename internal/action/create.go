@@ -0,0 +1,75 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/go-acme/lego/v4/challenge"
+)
+
+// CreateDomain creates a CDN domain, waits for Qiniu to bring it online, then requests
+// a certificate for it and binds it. A domain requesting HTTPS is created with HTTP first,
+// since its certificate can only be issued once it exists. A domain that already exists,
+// e.g. from an earlier attempt that failed later on, is not created again.
+func CreateDomain(ctx context.Context, qiniu *qiniuapi.QiniuClient, dnsProvider challenge.Provider, req qiniuapi.CreateDomainRequest, opts Options) error {
+	if qiniu == nil {
+		return fmt.Errorf("qiniu client is required")
+	}
+
+	if opts.Domain == "" {
+		return fmt.Errorf("domain name is required")
+	}
+
+	// Qiniu names wildcard domains .example.com
+	if wildcard := strings.HasPrefix(opts.Domain, "."); wildcard != (req.Type == qiniuapi.DomainTypeWildcard) {
+		if wildcard {
+			return fmt.Errorf("domain %s is a wildcard domain, create it with type %s", opts.Domain, qiniuapi.DomainTypeWildcard)
+		}
+		return fmt.Errorf("wildcard domain %s must be named like .example.com", opts.Domain)
+	}
+
+	if req.Source == nil || req.Source.SourceType == "" {
+		return fmt.Errorf("source of domain %s is required", opts.Domain)
+	}
+
+	// Creating a domain takes minutes, the certificate cannot be bound before it is online
+	if opts.WaitTimeout <= 0 {
+		return fmt.Errorf("a wait timeout is required to create domain %s", opts.Domain)
+	}
+
+	https := req.Protocol == "https"
+	req.Protocol = "http"
+	req.HTTPS = nil
+
+	_, err := qiniu.GetDomainInfo(ctx, opts.Domain)
+	switch {
+	case err == nil:
+		log.Printf("Domain %s already exists, skipping creation", opts.Domain)
+	case qiniuapi.IsNotFound(err):
+		log.Printf("Creating domain %s with %s origin...", opts.Domain, req.Source.SourceType)
+		if err := qiniu.CreateDomain(ctx, opts.Domain, req); err != nil {
+			return fmt.Errorf("domain %s: %w", opts.Domain, err)
+		}
+	default:
+		return fmt.Errorf("failed to check whether domain %s exists: %w", opts.Domain, err)
+	}
+
+	domainInfo, err := waitForDomain(ctx, qiniu, opts.Domain, opts.WaitTimeout)
+	if err != nil {
+		return err
+	}
+	log.Printf("Domain %s has been created, point it to %s", opts.Domain, domainInfo.CNAME)
+
+	if !https {
+		return nil
+	}
+
+	if err := Run(ctx, qiniu, dnsProvider, opts); err != nil {
+		return fmt.Errorf("domain %s has been created but HTTPS is not enabled, rerun this command or renew it with --domain %s: %w", opts.Domain, opts.Domain, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+)
+
+// SupersededCertificates returns the certificates uploaded by this tool that are not bound
+// to any domain and were superseded by a newer certificate of the same name more than
+// gracePeriod ago. Certificates uploaded by humans or other tools are never returned, and
+// neither are the ones the deployment history in certDir may roll back to.
+func SupersededCertificates(ctx context.Context, qiniu *qiniuapi.QiniuClient, certDir string, gracePeriod time.Duration) ([]qiniuapi.CertificateInfo, error) {
+	history, err := loadHistory(certDir)
+	if err != nil {
+		return nil, err
+	}
+	rollbackTargets := make(map[string]bool, len(history))
+	for _, d := range history {
+		rollbackTargets[d.PreviousCertID] = true
+	}
+
+	certs, err := qiniu.ListAllCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings, err := qiniu.GetCertificateBindings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the newest certificate uploaded by this tool for each name
+	newest := make(map[string]qiniuapi.CertificateInfo)
+	for _, cert := range certs {
+		if !cert.UploadedByTool() {
+			continue
+		}
+		if cert.CreateTime > newest[cert.Name].CreateTime {
+			newest[cert.Name] = cert
+		}
+	}
+
+	var superseded []qiniuapi.CertificateInfo
+	for _, cert := range certs {
+		if !cert.UploadedByTool() || len(bindings[cert.ID]) > 0 || rollbackTargets[cert.ID] {
+			continue
+		}
+
+		successor := newest[cert.Name]
+		if successor.ID == cert.ID || successor.CreateTime <= cert.CreateTime {
+			continue
+		}
+
+		if time.Since(time.Unix(successor.CreateTime, 0)) < gracePeriod {
+			continue
+		}
+
+		superseded = append(superseded, cert)
+	}
+
+	return superseded, nil
+}
+
+// CollectCertificates deletes the certificates returned by SupersededCertificates.
+// If dryRun is true, the certificates are only logged.
+func CollectCertificates(ctx context.Context, qiniu *qiniuapi.QiniuClient, certDir string, gracePeriod time.Duration, dryRun bool) error {
+	certs, err := SupersededCertificates(ctx, qiniu, certDir, gracePeriod)
+	if err != nil {
+		return fmt.Errorf("failed to find superseded certificates: %w", err)
+	}
+
+	if len(certs) == 0 {
+		log.Printf("No superseded certificates to delete")
+		return nil
+	}
+
+	for _, cert := range certs {
+		if dryRun {
+			log.Printf("Dry run: would delete superseded certificate %s (%s, expires %s)",
+				cert.ID, cert.Name, time.Unix(cert.NotAfter, 0).Format("2006-01-02"))
+			continue
+		}
+
+		if err := qiniu.DeleteCertificate(ctx, cert.ID); err != nil {
+			return fmt.Errorf("failed to delete certificate %s: %w", cert.ID, err)
+		}
+		log.Printf("Deleted superseded certificate %s (%s)", cert.ID, cert.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package action
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// historyFileName is the name of the deployment history file in the certificate directory
+const historyFileName = "qiniu-history.json"
+
+// deployment records the HTTPS binding a domain had before a certificate was deployed,
+// so that it can be restored
+type deployment struct {
+	CertID             string    `json:"certId"`
+	PreviousCertID     string    `json:"previousCertId"`
+	PreviousForceHTTPS bool      `json:"previousForceHttps"`
+	PreviousHTTP2      bool      `json:"previousHttp2Enable"`
+	DeployedAt         time.Time `json:"deployedAt"`
+}
+
+// loadHistory reads the deployments recorded in the certificate directory by domain
+func loadHistory(certDir string) (map[string]deployment, error) {
+	history := make(map[string]deployment)
+
+	data, err := os.ReadFile(filepath.Join(certDir, historyFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment history: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse deployment history: %v", err)
+	}
+
+	return history, nil
+}
+
+// saveHistory writes the deployment history atomically
+func saveHistory(certDir string, history map[string]deployment) error {
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(certDir, historyFileName)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write deployment history: %v", err)
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write deployment history: %v", err)
+	}
+
+	return nil
+}
+
+// recordDeployment records the deployment of a certificate to a domain, or forgets
+// the last deployment to the domain if d is nil
+func recordDeployment(certDir, domain string, d *deployment) error {
+	history, err := loadHistory(certDir)
+	if err != nil {
+		return err
+	}
+
+	if d == nil {
+		delete(history, domain)
+	} else {
+		history[domain] = *d
+	}
+
+	return saveHistory(certDir, history)
+}
+
+// forgetStaleDeployment deletes the history entry of the domain if it records a deployment
+// of another certificate than certID, so that its previous certificate is no longer kept
+func forgetStaleDeployment(certDir, domain, certID string) error {
+	history, err := loadHistory(certDir)
+	if err != nil {
+		return err
+	}
+
+	if d, ok := history[domain]; !ok || d.CertID == certID {
+		return nil
+	}
+
+	delete(history, domain)
+	return saveHistory(certDir, history)
+}
@@ -0,0 +1,56 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/WqyJh/qiniu-ssl/internal/certcheck"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+)
+
+// deployableBucketDomain retrieves a custom domain of a Kodo bucket, failing if it is
+// not bound to the bucket
+func deployableBucketDomain(ctx context.Context, qiniu *qiniuapi.QiniuClient, bucket, domain string) (*qiniuapi.BucketDomain, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket of domain %s is required", domain)
+	}
+
+	log.Printf("Retrieving domain information for %s in bucket %s...", domain, bucket)
+	bucketDomain, err := qiniu.GetBucketDomain(ctx, bucket, domain)
+	if err != nil {
+		if qiniuapi.IsNotFound(err) {
+			return nil, fmt.Errorf("domain %s not found in bucket %s, bind it in the Qiniu console first: %w", domain, bucket, err)
+		}
+		return nil, fmt.Errorf("failed to retrieve bucket domain information: %w", err)
+	}
+
+	return bucketDomain, nil
+}
+
+// deployToBucket uploads the certificate and binds it to the custom domain of a Kodo bucket,
+// then verifies it. Kodo applies the binding immediately, there is nothing to wait for or
+// roll back.
+func deployToBucket(ctx context.Context, qiniu *qiniuapi.QiniuClient, bucketDomain *qiniuapi.BucketDomain, certPEM, keyPEM []byte, opts Options) error {
+	domain := opts.Domain
+
+	if err := certcheck.Validate(certPEM, keyPEM, domain); err != nil {
+		return fmt.Errorf("certificate cannot be deployed to %s: %w", domain, err)
+	}
+
+	certID, err := uploadAndBind(ctx, qiniu, certPEM, keyPEM, opts, func(certID string) error {
+		if bucketDomain.CertID == certID {
+			log.Printf("Certificate %s is already bound to domain %s", certID, domain)
+			return nil
+		}
+
+		log.Printf("Binding certificate %s to domain %s of bucket %s...", certID, domain, bucketDomain.Bucket)
+		return qiniu.BindBucketDomainCertificate(ctx, domain, certID)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Certificate %s has been bound to domain %s", certID, domain)
+
+	return verifyDeployment(ctx, "", certPEM, opts)
+}
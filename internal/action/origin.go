@@ -0,0 +1,97 @@
+package action
+
+import (
+	"context"
+	"crypto/x509"
+	"log"
+	"net"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/certcheck"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/WqyJh/qiniu-ssl/internal/verify"
+)
+
+// originPort is the port of HTTPS origins that do not include one
+const originPort = "443"
+
+// OriginCertificate is the certificate served by an HTTPS origin of a CDN domain
+type OriginCertificate struct {
+	Domain      string            // CDN domain
+	Host        string            // Host header sent to the origin, used for SNI
+	Addr        string            // Origin address probed
+	Certificate *x509.Certificate // nil if it could not be retrieved
+	Err         error             // why it could not be retrieved or is not valid for Host
+}
+
+// Expiring reports whether the certificate expires within thresholdDays
+func (o *OriginCertificate) Expiring(thresholdDays int) bool {
+	return o.Certificate != nil && time.Now().AddDate(0, 0, thresholdDays).After(o.Certificate.NotAfter)
+}
+
+// ProbeOrigins retrieves by TLS handshake the certificates served by the origins of a CDN
+// domain, sending the configured origin host, or the domain, for SNI. It returns nil if Qiniu
+// does not fetch from the origins over HTTPS or they are Qiniu buckets.
+func ProbeOrigins(ctx context.Context, domainInfo *qiniuapi.DomainInfo) []OriginCertificate {
+	source := domainInfo.Source
+	if source == nil || !source.UsesHTTPS(domainInfo.Protocol) {
+		return nil
+	}
+
+	host := source.SourceHost
+	if host == "" {
+		host = domainInfo.Name
+	}
+
+	var origins []OriginCertificate
+	for _, addr := range source.Addrs() {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, originPort)
+		}
+
+		origin := OriginCertificate{Domain: domainInfo.Name, Host: host, Addr: addr}
+		origin.Certificate, origin.Err = verify.ServedCertificate(ctx, addr, host)
+		if origin.Err == nil && !certcheck.Covers(origin.Certificate, host) {
+			origin.Err = &certcheck.DomainMismatchError{Domain: host, Names: origin.Certificate.DNSNames}
+		}
+		origins = append(origins, origin)
+	}
+
+	return origins
+}
+
+// CheckOrigins probes the HTTPS origins of CDN domains and reports the certificates that are
+// expiring within thresholdDays, not valid for the origin host or cannot be retrieved.
+// It only reports them: the origin servers are not managed by Qiniu, a certificate issued
+// and bound on Qiniu would never reach them. It returns every origin certificate probed.
+func CheckOrigins(ctx context.Context, qiniu *qiniuapi.QiniuClient, domains []string, thresholdDays int) ([]OriginCertificate, error) {
+	var origins []OriginCertificate
+	for _, domain := range domains {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		domainInfo, err := qiniu.GetDomainInfo(ctx, domain)
+		if err != nil {
+			log.Printf("Failed to check origins of %s: %v", domain, err)
+			continue
+		}
+
+		if domainInfo.IsDisabled() {
+			continue
+		}
+
+		for _, origin := range ProbeOrigins(ctx, domainInfo) {
+			switch {
+			case origin.Err != nil:
+				log.Printf("Warning: HTTPS origin %s (host %s) of %s: %v", origin.Addr, origin.Host, domain, origin.Err)
+			case origin.Expiring(thresholdDays):
+				log.Printf("Warning: certificate served by HTTPS origin %s (host %s) of %s expires on %s, renew it on the origin server",
+					origin.Addr, origin.Host, domain, origin.Certificate.NotAfter.Format("2006-01-02"))
+			}
+			origins = append(origins, origin)
+		}
+	}
+
+	return origins, nil
+}
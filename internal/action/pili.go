@@ -0,0 +1,59 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/WqyJh/qiniu-ssl/internal/certcheck"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+)
+
+// deployablePiliDomain retrieves a domain of a live-streaming hub, failing if it does not
+// exist or is disabled
+func deployablePiliDomain(ctx context.Context, qiniu *qiniuapi.QiniuClient, hub, domain string) (*qiniuapi.PiliDomain, error) {
+	if hub == "" {
+		return nil, fmt.Errorf("live-streaming hub of domain %s is required", domain)
+	}
+
+	log.Printf("Retrieving domain information for %s in live-streaming hub %s...", domain, hub)
+	piliDomain, err := qiniu.GetPiliDomain(ctx, hub, domain)
+	if err != nil {
+		if qiniuapi.IsNotFound(err) {
+			return nil, fmt.Errorf("domain %s not found in live-streaming hub %s, add it in the Qiniu console first: %w", domain, hub, err)
+		}
+		return nil, fmt.Errorf("failed to retrieve live-streaming domain information: %w", err)
+	}
+
+	if piliDomain.Disable {
+		return nil, fmt.Errorf("domain %s is disabled in live-streaming hub %s, its certificate cannot be changed", domain, hub)
+	}
+
+	return piliDomain, nil
+}
+
+// deployToPili uploads the certificate and binds it to the domain of a live-streaming hub,
+// then verifies it
+func deployToPili(ctx context.Context, qiniu *qiniuapi.QiniuClient, piliDomain *qiniuapi.PiliDomain, certPEM, keyPEM []byte, opts Options) error {
+	domain := opts.Domain
+
+	if err := certcheck.Validate(certPEM, keyPEM, domain); err != nil {
+		return fmt.Errorf("certificate cannot be deployed to %s: %w", domain, err)
+	}
+
+	certID, err := uploadAndBind(ctx, qiniu, certPEM, keyPEM, opts, func(certID string) error {
+		if piliDomain.CertEnable && piliDomain.CertID == certID {
+			log.Printf("Certificate %s is already bound to domain %s", certID, domain)
+			return nil
+		}
+
+		log.Printf("Binding certificate %s to %s domain %s of live-streaming hub %s...", certID, piliDomain.Type, domain, opts.Hub)
+		return qiniu.BindPiliDomainCertificate(ctx, opts.Hub, domain, certID)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Certificate %s has been bound to domain %s", certID, domain)
+
+	return verifyDeployment(ctx, piliDomain.CNAME, certPEM, opts)
+}
@@ -0,0 +1,66 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+)
+
+// HasDesiredHTTPS reports whether opts require specific force HTTPS or HTTP/2 settings,
+// rather than keeping whatever the domain currently has. Only CDN domains have them.
+func (opts Options) HasDesiredHTTPS() bool {
+	if opts.Target != "" && opts.Target != TargetCDN {
+		return false
+	}
+	return !opts.PreserveHTTPS || opts.OverrideForceHTTPS || opts.OverrideHTTP2
+}
+
+// ReconcileHTTPS compares the force HTTPS and HTTP/2 settings of the domain with the ones
+// required by opts and corrects any drift, leaving the bound certificate untouched.
+// Domains without HTTPS are left alone, they get their settings when a certificate is bound,
+// and so are frozen or offline domains.
+// It returns whether the settings had drifted.
+func ReconcileHTTPS(ctx context.Context, qiniu *qiniuapi.QiniuClient, opts Options) (bool, error) {
+	domain := opts.Domain
+
+	domainInfo, err := qiniu.GetDomainInfo(ctx, domain)
+	if err != nil {
+		return false, fmt.Errorf("failed to retrieve domain information: %w", err)
+	}
+
+	current := domainInfo.HTTPS
+	if current == nil || current.CertID == "" || domainInfo.IsDisabled() {
+		return false, nil
+	}
+
+	forceHTTPS, http2 := opts.httpsSettings(current)
+	if forceHTTPS == current.ForceHttps && http2 == current.Http2Enable {
+		return false, nil
+	}
+
+	log.Printf("HTTPS settings of domain %s have drifted: force HTTPS %t (want %t), HTTP/2 %t (want %t)",
+		domain, current.ForceHttps, forceHTTPS, current.Http2Enable, http2)
+
+	// A previous operation on the domain must finish before the HTTPS configuration can change
+	if domainInfo.IsProcessing() {
+		if _, err := waitForDomain(ctx, qiniu, domain, opts.WaitTimeout); err != nil {
+			return true, err
+		}
+	}
+
+	if err := qiniu.UpdateHTTPSConfig(ctx, domain, current.CertID, forceHTTPS, http2); err != nil {
+		if qiniuapi.IsDomainProcessing(err) {
+			return true, fmt.Errorf("domain %s is being processed by Qiniu, try again later: %w", domain, err)
+		}
+		return true, fmt.Errorf("failed to update HTTPS configuration: %w", err)
+	}
+
+	if _, err := waitForDomain(ctx, qiniu, domain, opts.WaitTimeout); err != nil {
+		return true, err
+	}
+
+	log.Printf("HTTPS settings of domain %s have been corrected", domain)
+	return true, nil
+}
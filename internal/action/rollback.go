@@ -0,0 +1,119 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/WqyJh/qiniu-ssl/internal/verify"
+)
+
+// RolledBackError reports a failed deployment after which the previous certificate
+// was bound to the domain again
+type RolledBackError struct {
+	Domain         string
+	CertID         string // Certificate that failed to deploy
+	RestoredCertID string // Certificate bound again
+	Err            error  // Why the deployment failed
+}
+
+func (e *RolledBackError) Error() string {
+	return fmt.Sprintf("deployment of certificate %s to %s failed and was rolled back to certificate %s: %v",
+		e.CertID, e.Domain, e.RestoredCertID, e.Err)
+}
+
+func (e *RolledBackError) Unwrap() error {
+	return e.Err
+}
+
+// Rollback binds the certificate a domain had before the last deployment by this tool again,
+// along with its force HTTPS and HTTP/2 settings
+func Rollback(ctx context.Context, qiniu *qiniuapi.QiniuClient, certDir, domain string, waitTimeout time.Duration) error {
+	history, err := loadHistory(certDir)
+	if err != nil {
+		return err
+	}
+
+	last, ok := history[domain]
+	if !ok {
+		return fmt.Errorf("no previous certificate recorded for domain %s", domain)
+	}
+
+	domainInfo, err := qiniu.GetDomainInfo(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve domain information: %w", err)
+	}
+
+	if domainInfo.HTTPS != nil && domainInfo.HTTPS.CertID != last.CertID {
+		log.Printf("Warning: domain %s is bound to certificate %s, not to %s deployed at %s",
+			domain, domainInfo.HTTPS.CertID, last.CertID, last.DeployedAt.Format(time.RFC3339))
+	}
+
+	if err := restoreBinding(ctx, qiniu, domain, last, waitTimeout); err != nil {
+		return err
+	}
+
+	return recordDeployment(certDir, domain, nil)
+}
+
+// rollbackDeployment restores the previous binding of the domain after a deployment failed
+// with err, if the failure warrants it. It returns a *RolledBackError if it did.
+func rollbackDeployment(ctx context.Context, qiniu *qiniuapi.QiniuClient, previous *deployment, err error, opts Options) error {
+	var operationErr *qiniuapi.DomainOperationError
+	var mismatchErr *verify.MismatchError
+	if !opts.Rollback || previous == nil || (!errors.As(err, &operationErr) && !errors.As(err, &mismatchErr)) {
+		return err
+	}
+
+	log.Printf("Deployment to %s failed, rolling back to certificate %s: %v", opts.Domain, previous.PreviousCertID, err)
+	if rollbackErr := restoreBinding(ctx, qiniu, opts.Domain, *previous, opts.WaitTimeout); rollbackErr != nil {
+		return fmt.Errorf("%w; rollback to certificate %s failed: %v", err, previous.PreviousCertID, rollbackErr)
+	}
+
+	if recordErr := recordDeployment(opts.CertDir, opts.Domain, nil); recordErr != nil {
+		log.Printf("Warning: %v", recordErr)
+	}
+
+	return &RolledBackError{
+		Domain:         opts.Domain,
+		CertID:         previous.CertID,
+		RestoredCertID: previous.PreviousCertID,
+		Err:            err,
+	}
+}
+
+// restoreBinding binds the previous certificate of a deployment to the domain again
+func restoreBinding(ctx context.Context, qiniu *qiniuapi.QiniuClient, domain string, d deployment, waitTimeout time.Duration) error {
+	domainInfo, err := qiniu.GetDomainInfo(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve domain information: %w", err)
+	}
+
+	if domainInfo.IsProcessing() {
+		if _, err := waitForDomain(ctx, qiniu, domain, waitTimeout); err != nil {
+			// The failure of the operation being rolled back is expected here
+			var operationErr *qiniuapi.DomainOperationError
+			if !errors.As(err, &operationErr) {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Binding previous certificate %s to domain %s...", d.PreviousCertID, domain)
+	if err := qiniu.UpdateHTTPSConfig(ctx, domain, d.PreviousCertID, d.PreviousForceHTTPS, d.PreviousHTTP2); err != nil {
+		if qiniuapi.IsCertNotFound(err) {
+			return fmt.Errorf("previous certificate %s no longer exists in Qiniu: %w", d.PreviousCertID, err)
+		}
+		return fmt.Errorf("failed to update HTTPS configuration: %w", err)
+	}
+
+	if _, err := waitForDomain(ctx, qiniu, domain, waitTimeout); err != nil {
+		return err
+	}
+
+	log.Printf("Domain %s is bound to certificate %s again", domain, d.PreviousCertID)
+	return nil
+}
@@ -0,0 +1,136 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/WqyJh/qiniu-ssl/internal/certcache"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+)
+
+// scannedCertDirs holds the certificate directories whose cache was filled with all the
+// certificates of the account, which is done once per process
+var scannedCertDirs sync.Map
+
+// uploadCertificate uploads a certificate to Qiniu, reusing an identical certificate
+// that is already uploaded. The IDs of known certificates are cached in certDir so that
+// reusing a certificate costs no API calls, and a certificate missing from the cache is
+// uploaded without listing the certificates of the account again.
+// It returns the certificate ID and whether an existing certificate was reused.
+func uploadCertificate(ctx context.Context, qiniu *qiniuapi.QiniuClient, certDir, name string, certPEM, keyPEM []byte) (string, bool, error) {
+	marker, err := qiniuapi.NewCertificateMarker(certPEM)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	cache, err := certcache.OpenDir(certDir)
+	if err != nil {
+		log.Printf("Warning: %v, ignoring the certificate cache", err)
+		cache = nil
+	}
+
+	if cache != nil {
+		if certID, ok := cache.Get(marker.Fingerprint); ok {
+			log.Printf("Reusing certificate %s already uploaded to Qiniu (cached)", certID)
+			return certID, true, nil
+		}
+	}
+
+	certID, reused, err := findUploadedCertificate(ctx, qiniu, certDir, cache, marker.Fingerprint)
+	if err != nil {
+		return "", false, err
+	}
+
+	if reused {
+		log.Printf("Reusing certificate %s already uploaded to Qiniu", certID)
+	} else {
+		certID, err = qiniu.UploadCertificate(ctx, name, certPEM, keyPEM)
+		if err != nil {
+			if qiniuapi.IsInvalidCertificate(err) {
+				return "", false, fmt.Errorf("Qiniu rejected the certificate for %s: %w", name, err)
+			}
+			return "", false, fmt.Errorf("failed to upload certificate: %w", err)
+		}
+	}
+
+	if cache != nil {
+		if err := cache.Put(marker.Fingerprint, certID); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	return certID, reused, nil
+}
+
+// uploadAndBind uploads the certificate like uploadCertificate and binds it with bind.
+// A reused certificate deleted from Qiniu in the meantime is uploaded again.
+// It returns the ID of the bound certificate.
+func uploadAndBind(ctx context.Context, qiniu *qiniuapi.QiniuClient, certPEM, keyPEM []byte, opts Options, bind func(certID string) error) (string, error) {
+	log.Printf("Uploading certificate to Qiniu...")
+	certID, reused, err := uploadCertificate(ctx, qiniu, opts.CertDir, opts.Domain, certPEM, keyPEM)
+	if err != nil {
+		return "", err
+	}
+	if !reused {
+		log.Printf("Certificate has been uploaded to Qiniu with ID: %s", certID)
+	}
+
+	err = bind(certID)
+	if err != nil && reused && qiniuapi.IsCertNotFound(err) {
+		log.Printf("Certificate %s no longer exists in Qiniu, uploading it again...", certID)
+		forgetCertificate(opts.CertDir, certPEM)
+		if certID, _, err = uploadCertificate(ctx, qiniu, opts.CertDir, opts.Domain, certPEM, keyPEM); err != nil {
+			return "", err
+		}
+		log.Printf("Certificate has been uploaded to Qiniu with ID: %s", certID)
+		err = bind(certID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return certID, nil
+}
+
+// findUploadedCertificate looks for an uploaded certificate with the fingerprint among the
+// certificates of the account, uploaded by other machines or by hand. The certificates found
+// are added to the cache, and the account is only listed again if there is no cache.
+func findUploadedCertificate(ctx context.Context, qiniu *qiniuapi.QiniuClient, certDir string, cache *certcache.Cache, fingerprint string) (string, bool, error) {
+	if _, scanned := scannedCertDirs.Load(certDir); scanned && cache != nil {
+		return "", false, nil
+	}
+
+	uploaded, err := qiniu.CertificateFingerprints(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to look up existing certificates: %w", err)
+	}
+
+	if cache != nil {
+		if err := cache.PutMissing(uploaded); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		scannedCertDirs.Store(certDir, true)
+	}
+
+	certID, ok := uploaded[fingerprint]
+	return certID, ok, nil
+}
+
+// forgetCertificate removes a certificate from the cache, e.g. after it was deleted from Qiniu
+func forgetCertificate(certDir string, certPEM []byte) {
+	marker, err := qiniuapi.NewCertificateMarker(certPEM)
+	if err != nil {
+		return
+	}
+
+	cache, err := certcache.OpenDir(certDir)
+	if err != nil {
+		return
+	}
+
+	if err := cache.Delete(marker.Fingerprint); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
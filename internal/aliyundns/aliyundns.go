@@ -2,23 +2,67 @@ package aliyundns
 
 import (
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/alidns"
 	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/platform/config/env"
 )
 
+const (
+	// pageSize is the page size used when listing zones and records
+	pageSize = 100
+
+	// DefaultTTL is the default TTL of challenge records
+	DefaultTTL = 600
+
+	// DefaultLine is the default resolution line of challenge records
+	DefaultLine = "default"
+
+	// IntlEndpoint is the DNS API endpoint of Alibaba Cloud international
+	IntlEndpoint = "alidns.ap-southeast-1.aliyuncs.com"
+)
+
 // DNSProvider implements the challenge.Provider interface for Aliyun DNS
 type DNSProvider struct {
 	client   *alidns.Client
 	waitTime time.Duration
 	zoneName string
+	endpoint string
+	ttl      int64
+	line     string
+	minTTLs  map[string]int64
+}
+
+// Option configures a DNSProvider
+type Option func(*DNSProvider)
+
+// WithEndpoint sets the DNS API endpoint, e.g. IntlEndpoint
+func WithEndpoint(endpoint string) Option {
+	return func(d *DNSProvider) {
+		d.endpoint = endpoint
+	}
+}
+
+// WithTTL sets the TTL of challenge records in seconds
+func WithTTL(ttl int64) Option {
+	return func(d *DNSProvider) {
+		d.ttl = ttl
+	}
+}
+
+// WithLine sets the resolution line of challenge records
+func WithLine(line string) Option {
+	return func(d *DNSProvider) {
+		d.line = line
+	}
 }
 
 // NewDNSProvider returns a new Aliyun DNS provider
-func NewDNSProvider(accessKey, secretKey, regionID string) (*DNSProvider, error) {
+func NewDNSProvider(accessKey, secretKey, regionID string, opts ...Option) (*DNSProvider, error) {
 	if accessKey == "" || secretKey == "" {
 		return nil, fmt.Errorf("Aliyun DNS: access key and secret key are required")
 	}
@@ -35,10 +79,30 @@ func NewDNSProvider(accessKey, secretKey, regionID string) (*DNSProvider, error)
 
 	waitTime := env.GetOrDefaultSecond("ALIYUN_POLLING_INTERVAL", 60)
 
-	return &DNSProvider{
+	d := &DNSProvider{
 		client:   client,
 		waitTime: time.Duration(waitTime) * time.Second,
-	}, nil
+		ttl:      DefaultTTL,
+		line:     DefaultLine,
+		minTTLs:  make(map[string]int64),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.ttl <= 0 {
+		return nil, fmt.Errorf("Aliyun DNS: TTL must be greater than 0")
+	}
+
+	if d.line == "" {
+		d.line = DefaultLine
+	}
+
+	if d.endpoint != "" {
+		client.Domain = d.endpoint
+	}
+
+	return d, nil
 }
 
 // Present creates a TXT record to fulfill the DNS-01 challenge
@@ -52,6 +116,11 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 	}
 	d.zoneName = zoneName
 
+	ttl, err := d.getRecordTTL(zoneName)
+	if err != nil {
+		return fmt.Errorf("Aliyun DNS: %v", err)
+	}
+
 	// Create a new DNS record
 	recordName := d.getRecordName(fqdn, zoneName)
 	request := alidns.CreateAddDomainRecordRequest()
@@ -59,13 +128,22 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 	request.Type = "TXT"
 	request.RR = recordName
 	request.Value = value
-	request.TTL = "600"
+	request.TTL = requests.NewInteger64(ttl)
+	request.Line = d.line
 
-	_, err = d.client.AddDomainRecord(request)
+	response, err := d.client.AddDomainRecord(request)
 	if err != nil {
 		return fmt.Errorf("Aliyun DNS: %v", err)
 	}
 
+	// Mark the record as ours so that it can be swept if CleanUp never runs
+	remarkRequest := alidns.CreateUpdateDomainRecordRemarkRequest()
+	remarkRequest.RecordId = response.RecordId
+	remarkRequest.Remark = challengeRemark(time.Now())
+	if _, err := d.client.UpdateDomainRecordRemark(remarkRequest); err != nil {
+		log.Printf("Aliyun DNS: failed to set remark on record %s: %v", response.RecordId, err)
+	}
+
 	return nil
 }
 
@@ -98,6 +176,33 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	return nil
 }
 
+// AddCAARecord adds a CAA record with tag authorizing issuer at name
+func (d *DNSProvider) AddCAARecord(name, tag, issuer string) error {
+	zoneName, err := d.getHostedZone(name)
+	if err != nil {
+		return fmt.Errorf("Aliyun DNS: %v", err)
+	}
+
+	ttl, err := d.getRecordTTL(zoneName)
+	if err != nil {
+		return fmt.Errorf("Aliyun DNS: %v", err)
+	}
+
+	request := alidns.CreateAddDomainRecordRequest()
+	request.DomainName = zoneName
+	request.Type = "CAA"
+	request.RR = d.getRecordName(dns01.ToFqdn(name), zoneName)
+	request.Value = fmt.Sprintf("0 %s %q", tag, issuer)
+	request.TTL = requests.NewInteger64(ttl)
+	request.Line = d.line
+
+	if _, err := d.client.AddDomainRecord(request); err != nil {
+		return fmt.Errorf("Aliyun DNS: %v", err)
+	}
+
+	return nil
+}
+
 // Timeout returns the timeout for the DNS provider
 func (d *DNSProvider) Timeout() time.Duration {
 	return d.waitTime
@@ -105,17 +210,16 @@ func (d *DNSProvider) Timeout() time.Duration {
 
 // getHostedZone returns the hosted zone name for a domain
 func (d *DNSProvider) getHostedZone(domain string) (string, error) {
-	request := alidns.CreateDescribeDomainsRequest()
-	response, err := d.client.DescribeDomains(request)
+	zones, err := d.listZones()
 	if err != nil {
 		return "", err
 	}
 
 	var hostedZone string
-	for _, zone := range response.Domains.Domain {
-		if isZoneMatch(zone.DomainName, domain) {
-			if len(zone.DomainName) > len(hostedZone) {
-				hostedZone = zone.DomainName
+	for _, zone := range zones {
+		if isZoneMatch(zone, domain) {
+			if len(zone) > len(hostedZone) {
+				hostedZone = zone
 			}
 		}
 	}
@@ -127,6 +231,51 @@ func (d *DNSProvider) getHostedZone(domain string) (string, error) {
 	return hostedZone, nil
 }
 
+// getRecordTTL returns the configured TTL, raised to the zone's minimum TTL if needed
+func (d *DNSProvider) getRecordTTL(zone string) (int64, error) {
+	minTTL, ok := d.minTTLs[zone]
+	if !ok {
+		request := alidns.CreateDescribeDomainInfoRequest()
+		request.DomainName = zone
+		response, err := d.client.DescribeDomainInfo(request)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get zone info for %s: %v", zone, err)
+		}
+		minTTL = response.MinTtl
+		d.minTTLs[zone] = minTTL
+	}
+
+	if d.ttl < minTTL {
+		log.Printf("Aliyun DNS: TTL %d is below the minimum TTL %d of zone %s, using %d", d.ttl, minTTL, zone, minTTL)
+		return minTTL, nil
+	}
+
+	return d.ttl, nil
+}
+
+// listZones returns the names of all zones managed by the account
+func (d *DNSProvider) listZones() ([]string, error) {
+	var zones []string
+	for page := 1; ; page++ {
+		request := alidns.CreateDescribeDomainsRequest()
+		request.PageNumber = requests.NewInteger(page)
+		request.PageSize = requests.NewInteger(pageSize)
+
+		response, err := d.client.DescribeDomains(request)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, zone := range response.Domains.Domain {
+			zones = append(zones, zone.DomainName)
+		}
+
+		if len(response.Domains.Domain) < pageSize || int64(len(zones)) >= response.TotalCount {
+			return zones, nil
+		}
+	}
+}
+
 // isZoneMatch checks if a domain is a subdomain of a zone
 func isZoneMatch(zone, domain string) bool {
 	return strings.HasSuffix(domain, zone) || domain == zone
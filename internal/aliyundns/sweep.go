@@ -0,0 +1,132 @@
+package aliyundns
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/alidns"
+)
+
+const (
+	// challengeRecordPrefix is the record name prefix used by DNS-01 challenges
+	challengeRecordPrefix = "_acme-challenge"
+
+	// remarkPrefix marks the records created by this tool
+	remarkPrefix = "qiniu-ssl acme-challenge "
+)
+
+// ChallengeRecord represents an ACME challenge TXT record in a managed zone
+type ChallengeRecord struct {
+	RecordID  string
+	Zone      string
+	RR        string
+	Value     string
+	Owned     bool      // Created by this tool
+	CreatedAt time.Time // Only known for owned records
+}
+
+// FQDN returns the full name of the record
+func (r ChallengeRecord) FQDN() string {
+	if r.RR == "@" {
+		return r.Zone
+	}
+	return r.RR + "." + r.Zone
+}
+
+// Age returns how long ago the record was created, or 0 if unknown
+func (r ChallengeRecord) Age() time.Duration {
+	if r.CreatedAt.IsZero() {
+		return 0
+	}
+	return time.Since(r.CreatedAt)
+}
+
+// ListChallengeRecords returns the _acme-challenge TXT records in all managed zones
+func (d *DNSProvider) ListChallengeRecords() ([]ChallengeRecord, error) {
+	zones, err := d.listZones()
+	if err != nil {
+		return nil, fmt.Errorf("Aliyun DNS: %v", err)
+	}
+
+	var records []ChallengeRecord
+	for _, zone := range zones {
+		zoneRecords, err := d.listChallengeRecords(zone)
+		if err != nil {
+			return nil, fmt.Errorf("Aliyun DNS: zone %s: %v", zone, err)
+		}
+		records = append(records, zoneRecords...)
+	}
+
+	return records, nil
+}
+
+// DeleteRecord deletes a DNS record by ID
+func (d *DNSProvider) DeleteRecord(recordID string) error {
+	request := alidns.CreateDeleteDomainRecordRequest()
+	request.RecordId = recordID
+	if _, err := d.client.DeleteDomainRecord(request); err != nil {
+		return fmt.Errorf("Aliyun DNS: %v", err)
+	}
+	return nil
+}
+
+// listChallengeRecords returns the _acme-challenge TXT records in a zone
+func (d *DNSProvider) listChallengeRecords(zone string) ([]ChallengeRecord, error) {
+	var records []ChallengeRecord
+	for page, seen := 1, 0; ; page++ {
+		request := alidns.CreateDescribeDomainRecordsRequest()
+		request.DomainName = zone
+		request.RRKeyWord = challengeRecordPrefix
+		request.Type = "TXT"
+		request.PageNumber = requests.NewInteger(page)
+		request.PageSize = requests.NewInteger(pageSize)
+
+		response, err := d.client.DescribeDomainRecords(request)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range response.DomainRecords.Record {
+			// RRKeyWord is a fuzzy match, so filter on the prefix again
+			if !strings.HasPrefix(record.RR, challengeRecordPrefix) {
+				continue
+			}
+			createdAt, owned := parseChallengeRemark(record.Remark)
+			records = append(records, ChallengeRecord{
+				RecordID:  record.RecordId,
+				Zone:      zone,
+				RR:        record.RR,
+				Value:     record.Value,
+				Owned:     owned,
+				CreatedAt: createdAt,
+			})
+		}
+
+		seen += len(response.DomainRecords.Record)
+		if len(response.DomainRecords.Record) < pageSize || int64(seen) >= response.TotalCount {
+			return records, nil
+		}
+	}
+}
+
+// challengeRemark returns the remark set on challenge records created by this tool
+func challengeRemark(createdAt time.Time) string {
+	return remarkPrefix + createdAt.UTC().Format(time.RFC3339)
+}
+
+// parseChallengeRemark parses a remark written by challengeRemark. The creation time is
+// zero if the remark is ours but the time cannot be parsed.
+func parseChallengeRemark(remark string) (time.Time, bool) {
+	if !strings.HasPrefix(remark, remarkPrefix) {
+		return time.Time{}, false
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, strings.TrimPrefix(remark, remarkPrefix))
+	if err != nil {
+		return time.Time{}, true
+	}
+
+	return createdAt, true
+}
@@ -0,0 +1,275 @@
+package caa
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// TagIssue authorizes a CA to issue certificates
+	TagIssue = "issue"
+
+	// TagIssueWild authorizes a CA to issue wildcard certificates
+	TagIssueWild = "issuewild"
+
+	// flagCritical is the issuer critical flag
+	flagCritical = 128
+
+	// defaultNameserver is used when the system resolver configuration cannot be read
+	defaultNameserver = "8.8.8.8:53"
+)
+
+// Record represents a CAA resource record
+type Record struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// String returns the record in presentation format
+func (r Record) String() string {
+	return fmt.Sprintf("%d %s %q", r.Flag, r.Tag, r.Value)
+}
+
+// BlockedError reports that the CAA records of a domain do not authorize the CA
+type BlockedError struct {
+	Domain  string   // Domain the certificate is requested for
+	Name    string   // Name the relevant CAA record set was found at
+	Issuer  string   // CA identity that is not authorized
+	Tag     string   // Tag that has to authorize the CA
+	Records []Record // Relevant CAA record set
+}
+
+func (e *BlockedError) Error() string {
+	records := make([]string, len(e.Records))
+	for i, record := range e.Records {
+		records[i] = record.String()
+	}
+	return fmt.Sprintf("CAA records at %s do not authorize %s to issue certificates for %s (found: %s), add a record: %s 0 %s %q",
+		e.Name, e.Issuer, e.Domain, strings.Join(records, ", "), e.Name, e.Tag, e.Issuer)
+}
+
+// CriticalTagError reports a CAA record with an unknown tag and the critical flag set, which
+// forbids every CA to issue certificates. Adding an issue record does not lift it.
+type CriticalTagError struct {
+	Domain string // Domain the certificate is requested for
+	Name   string // Name the relevant CAA record set was found at
+	Record Record // Offending record
+}
+
+func (e *CriticalTagError) Error() string {
+	return fmt.Sprintf("CAA record %s at %s has an unknown tag marked critical, no CA may issue certificates for %s, remove or fix the record",
+		e.Record, e.Name, e.Domain)
+}
+
+// Check verifies that the CAA records of domain authorize issuer to issue a certificate.
+// It returns a *BlockedError if they do not, or a *CriticalTagError if they forbid issuance
+// by any CA.
+func Check(domain, issuer string) error {
+	return check(domain, issuer, systemNameservers())
+}
+
+// Wait waits for the CAA records of domain to authorize issuer, checking every interval for
+// at most timeout, e.g. after a record was added. The authoritative nameservers are queried,
+// so that the answers cached by the system resolver do not delay it.
+func Wait(domain, issuer string, timeout, interval time.Duration) error {
+	nameservers, err := authoritativeNameservers(strings.TrimPrefix(domain, "*."))
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check(domain, issuer, nameservers)
+		if err == nil {
+			return nil
+		}
+
+		var critical *CriticalTagError
+		if errors.As(err, &critical) || time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("CAA records of %s still do not authorize %s after %s: %w", domain, issuer, timeout, err)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// check verifies the CAA records of domain, as served by the nameservers
+func check(domain, issuer string, nameservers []string) error {
+	setName, records, err := lookup(strings.TrimPrefix(domain, "*."), nameservers)
+	if err != nil {
+		return err
+	}
+
+	return evaluate(domain, setName, records, issuer)
+}
+
+// evaluate checks whether the relevant CAA record set of domain, found at setName,
+// authorizes issuer. A wildcard domain falls back to the issue records if the set has no
+// issuewild records.
+func evaluate(domain, setName string, records []Record, issuer string) error {
+	if len(records) == 0 {
+		// No CAA records, any CA may issue
+		return nil
+	}
+
+	for _, record := range records {
+		// An unknown tag with the critical flag set forbids issuance
+		if record.Flag&flagCritical != 0 && !isKnownTag(record.Tag) {
+			return &CriticalTagError{Domain: domain, Name: setName, Record: record}
+		}
+	}
+
+	tag := TagIssue
+	if strings.HasPrefix(domain, "*.") && hasTag(records, TagIssueWild) {
+		tag = TagIssueWild
+	}
+
+	if !authorized(records, tag, issuer) {
+		return &BlockedError{
+			Domain:  domain,
+			Name:    setName,
+			Issuer:  issuer,
+			Tag:     tag,
+			Records: records,
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the relevant CAA record set for name and the name it was found at,
+// climbing the DNS tree towards the root as described in RFC 8659
+func Lookup(name string) (string, []Record, error) {
+	return lookup(name, systemNameservers())
+}
+
+// lookup is Lookup querying the nameservers
+func lookup(name string, nameservers []string) (string, []Record, error) {
+	labels := dns.SplitDomainName(name)
+	for i := 0; i < len(labels)-1; i++ {
+		current := strings.Join(labels[i:], ".")
+
+		records, err := query(current, nameservers)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to look up CAA records for %s: %v", current, err)
+		}
+
+		if len(records) > 0 {
+			return current, records, nil
+		}
+	}
+
+	return "", nil, nil
+}
+
+// authorized checks whether the records with tag authorize issuer
+func authorized(records []Record, tag, issuer string) bool {
+	for _, record := range records {
+		if !strings.EqualFold(record.Tag, tag) {
+			continue
+		}
+
+		// The value is the issuer domain name, optionally followed by parameters
+		value, _, _ := strings.Cut(record.Value, ";")
+		if strings.EqualFold(strings.TrimSpace(value), issuer) {
+			return true
+		}
+	}
+
+	return !hasTag(records, tag)
+}
+
+// hasTag checks whether any record has tag
+func hasTag(records []Record, tag string) bool {
+	for _, record := range records {
+		if strings.EqualFold(record.Tag, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownTag checks whether tag is one defined by RFC 8659
+func isKnownTag(tag string) bool {
+	switch strings.ToLower(tag) {
+	case TagIssue, TagIssueWild, "iodef":
+		return true
+	}
+	return false
+}
+
+// query looks up the CAA records of name, returning none if the name does not exist
+func query(name string, nameservers []string) ([]Record, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeCAA)
+	msg.RecursionDesired = true
+
+	client := &dns.Client{Timeout: 10 * time.Second}
+
+	var lastErr error
+	for _, nameserver := range nameservers {
+		resp, _, err := client.Exchange(msg, nameserver)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		switch resp.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError:
+		default:
+			lastErr = fmt.Errorf("%s returned %s", nameserver, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+
+		var records []Record
+		for _, rr := range resp.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, Record{Flag: caa.Flag, Tag: caa.Tag, Value: caa.Value})
+			}
+		}
+		return records, nil
+	}
+
+	return nil, lastErr
+}
+
+// authoritativeNameservers returns the nameservers of the closest zone enclosing name
+func authoritativeNameservers(name string) ([]string, error) {
+	labels := dns.SplitDomainName(name)
+	for i := 0; i < len(labels)-1; i++ {
+		zone := strings.Join(labels[i:], ".")
+
+		records, err := net.LookupNS(zone)
+		if err != nil || len(records) == 0 {
+			continue
+		}
+
+		nameservers := make([]string, len(records))
+		for j, record := range records {
+			nameservers[j] = net.JoinHostPort(strings.TrimSuffix(record.Host, "."), "53")
+		}
+		return nameservers, nil
+	}
+
+	return nil, fmt.Errorf("failed to find the nameservers of %s", name)
+}
+
+// systemNameservers returns the nameservers from the system resolver configuration
+func systemNameservers() []string {
+	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(config.Servers) == 0 {
+		return []string{defaultNameserver}
+	}
+
+	nameservers := make([]string, len(config.Servers))
+	for i, server := range config.Servers {
+		nameservers[i] = net.JoinHostPort(server, config.Port)
+	}
+	return nameservers
+}
@@ -0,0 +1,86 @@
+package caa
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []Record
+		tag     string
+		want    bool
+	}{
+		{"issuer", []Record{{Tag: "issue", Value: "letsencrypt.org"}}, TagIssue, true},
+		{"issuer with parameters", []Record{{Tag: "issue", Value: " LetsEncrypt.org; validationmethods=dns-01"}}, TagIssue, true},
+		{"other issuer", []Record{{Tag: "issue", Value: "pki.goog"}}, TagIssue, false},
+		{"one of several issuers", []Record{{Tag: "issue", Value: "pki.goog"}, {Tag: "ISSUE", Value: "letsencrypt.org"}}, TagIssue, true},
+		{"no issuer allowed", []Record{{Tag: "issue", Value: ";"}}, TagIssue, false},
+		{"no record with tag", []Record{{Tag: "iodef", Value: "mailto:ca@example.com"}}, TagIssue, true},
+		{"wildcard issuer", []Record{{Tag: "issuewild", Value: "letsencrypt.org"}}, TagIssueWild, true},
+		{"issue record for wildcard tag", []Record{{Tag: "issue", Value: "letsencrypt.org"}, {Tag: "issuewild", Value: "pki.goog"}}, TagIssueWild, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authorized(tt.records, tt.tag, "letsencrypt.org"); got != tt.want {
+				t.Errorf("authorized(%v, %s) = %t, want %t", tt.records, tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	issueLE := Record{Tag: TagIssue, Value: "letsencrypt.org"}
+	issueOther := Record{Tag: TagIssue, Value: "pki.goog"}
+	wildLE := Record{Tag: TagIssueWild, Value: "letsencrypt.org"}
+	wildOther := Record{Tag: TagIssueWild, Value: "pki.goog"}
+	critical := Record{Flag: flagCritical, Tag: "tbs", Value: "unknown"}
+
+	blocked := func(tag string) func(error) bool {
+		return func(err error) bool {
+			var e *BlockedError
+			return errors.As(err, &e) && e.Tag == tag && e.Name == "example.com"
+		}
+	}
+
+	tests := []struct {
+		name    string
+		domain  string
+		records []Record
+		check   func(error) bool // nil if issuance is authorized
+	}{
+		{name: "no records", domain: "cdn.example.com"},
+		{name: "issue", domain: "cdn.example.com", records: []Record{issueLE}},
+		{name: "issue for another CA", domain: "cdn.example.com", records: []Record{issueOther}, check: blocked(TagIssue)},
+		{name: "issuewild ignored for names", domain: "cdn.example.com", records: []Record{issueLE, wildOther}},
+		{name: "issuewild does not authorize names", domain: "cdn.example.com", records: []Record{issueOther, wildLE}, check: blocked(TagIssue)},
+		{name: "wildcard falls back to issue", domain: "*.example.com", records: []Record{issueLE}},
+		{name: "wildcard falls back to issue for another CA", domain: "*.example.com", records: []Record{issueOther}, check: blocked(TagIssue)},
+		{name: "wildcard with issuewild", domain: "*.example.com", records: []Record{issueOther, wildLE}},
+		{name: "issuewild overrides issue", domain: "*.example.com", records: []Record{issueLE, wildOther}, check: blocked(TagIssueWild)},
+		{name: "non-critical unknown tag", domain: "cdn.example.com", records: []Record{issueLE, {Tag: "tbs", Value: "unknown"}}},
+		{
+			name: "critical unknown tag", domain: "cdn.example.com", records: []Record{issueLE, critical},
+			check: func(err error) bool {
+				var e *CriticalTagError
+				var b *BlockedError
+				return errors.As(err, &e) && e.Record == critical && !errors.As(err, &b)
+			},
+		},
+		{name: "critical known tag", domain: "cdn.example.com", records: []Record{{Flag: flagCritical, Tag: TagIssue, Value: "letsencrypt.org"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := evaluate(tt.domain, "example.com", tt.records, "letsencrypt.org")
+			switch {
+			case tt.check == nil && err != nil:
+				t.Errorf("evaluate: %v, want no error", err)
+			case tt.check != nil && !tt.check(err):
+				t.Errorf("evaluate: got %v (%T)", err, err)
+			}
+		})
+	}
+}
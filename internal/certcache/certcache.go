@@ -0,0 +1,107 @@
+package certcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileName is the name of the cache file in the certificate directory
+const FileName = "qiniu-certs.json"
+
+// Cache maps certificate fingerprints to the IDs of the certificates uploaded to Qiniu
+type Cache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+// Open loads the cache from path, starting empty if the file does not exist
+func Open(path string) (*Cache, error) {
+	c := &Cache{
+		path:    path,
+		entries: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate cache: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse certificate cache %s: %v", path, err)
+	}
+
+	return c, nil
+}
+
+// OpenDir loads the cache from the certificate directory
+func OpenDir(certDir string) (*Cache, error) {
+	return Open(filepath.Join(certDir, FileName))
+}
+
+// Get returns the Qiniu certificate ID for a fingerprint
+func (c *Cache) Get(fingerprint string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certID, ok := c.entries[fingerprint]
+	return certID, ok
+}
+
+// Put stores the Qiniu certificate ID for a fingerprint and saves the cache
+func (c *Cache) Put(fingerprint, certID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[fingerprint] = certID
+	return c.save()
+}
+
+// PutMissing stores the Qiniu certificate IDs of the fingerprints that are not cached yet
+// and saves the cache
+func (c *Cache) PutMissing(certIDs map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for fingerprint, certID := range certIDs {
+		if _, ok := c.entries[fingerprint]; !ok {
+			c.entries[fingerprint] = certID
+		}
+	}
+	return c.save()
+}
+
+// Delete removes a fingerprint and saves the cache
+func (c *Cache) Delete(fingerprint string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, fingerprint)
+	return c.save()
+}
+
+// save writes the cache file atomically
+func (c *Cache) save() error {
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write certificate cache: %v", err)
+	}
+
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to write certificate cache: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package certcheck
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// minRSABits is the smallest RSA key size accepted
+const minRSABits = 2048
+
+// KeyMismatchError reports that the private key does not belong to the certificate
+type KeyMismatchError struct {
+	Subject string // Common name of the certificate
+}
+
+func (e *KeyMismatchError) Error() string {
+	return fmt.Sprintf("private key does not match certificate %s", e.Subject)
+}
+
+// UnsupportedKeyError reports a key type or size Qiniu does not accept
+type UnsupportedKeyError struct {
+	Key    string // Which key, "certificate" or "private"
+	Reason string
+}
+
+func (e *UnsupportedKeyError) Error() string {
+	return fmt.Sprintf("unsupported %s key: %s", e.Key, e.Reason)
+}
+
+// ChainError reports an incomplete or misordered certificate chain
+type ChainError struct {
+	Index  int // Position in the chain of the offending certificate, the leaf being 0
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("invalid certificate chain at certificate %d: %s", e.Index, e.Reason)
+}
+
+// ValidityError reports a certificate that is not yet or no longer valid
+type ValidityError struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+	Expired   bool // NotAfter has passed, otherwise NotBefore has not been reached
+}
+
+func (e *ValidityError) Error() string {
+	if e.Expired {
+		return fmt.Sprintf("certificate expired at %s", e.NotAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("certificate is not valid before %s", e.NotBefore.Format(time.RFC3339))
+}
+
+// DomainMismatchError reports that the certificate does not cover a domain
+type DomainMismatchError struct {
+	Domain string
+	Names  []string // Names covered by the certificate
+}
+
+func (e *DomainMismatchError) Error() string {
+	return fmt.Sprintf("certificate for %s does not cover domain %s", strings.Join(e.Names, ", "), e.Domain)
+}
+
+// Validate checks a PEM encoded certificate chain and private key before they are uploaded:
+// the key matches the leaf and is of a supported type, the chain is ordered and complete,
+// the leaf is currently valid and covers domain. Domain is a Qiniu domain name, where
+// .example.com is a wildcard domain; it is not checked if empty.
+func Validate(certPEM, keyPEM []byte, domain string) error {
+	chain, err := parseChain(certPEM)
+	if err != nil {
+		return err
+	}
+	leaf := chain[0]
+
+	if err := checkPublicKey("certificate", leaf.PublicKey); err != nil {
+		return err
+	}
+
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return err
+	}
+
+	if err := checkKeyPair(leaf, key); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return &ValidityError{NotBefore: leaf.NotBefore, NotAfter: leaf.NotAfter, Expired: now.After(leaf.NotAfter)}
+	}
+
+	if err := checkChain(chain); err != nil {
+		return err
+	}
+
+	if domain != "" && !Covers(leaf, domain) {
+		return &DomainMismatchError{Domain: domain, Names: names(leaf)}
+	}
+
+	return nil
+}
+
+// Covers checks whether the certificate covers a Qiniu domain. A wildcard domain such as
+// .example.com requires a *.example.com certificate, other domains follow the usual rules
+// where a wildcard matches exactly one label.
+func Covers(cert *x509.Certificate, domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if strings.HasPrefix(domain, ".") {
+		domain = "*" + domain
+	}
+
+	for _, name := range names(cert) {
+		name = strings.ToLower(name)
+		if name == domain {
+			return true
+		}
+
+		// A wildcard name matches a single label, never a wildcard domain
+		if suffix, ok := strings.CutPrefix(name, "*."); ok && !strings.HasPrefix(domain, "*") {
+			label, rest, found := strings.Cut(domain, ".")
+			if found && label != "" && rest == suffix {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// names returns the DNS names of a certificate, falling back to the common name
+func names(cert *x509.Certificate) []string {
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames
+	}
+	return []string{cert.Subject.CommonName}
+}
+
+// parseChain parses the certificates in PEM data, leaf first
+func parseChain(certPEM []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected %s block in certificate file", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %d: %v", len(chain), err)
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate found in PEM data")
+	}
+
+	return chain, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #1, PKCS #8 or SEC 1 private key
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found in PEM data")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		return nil, &UnsupportedKeyError{Key: "private", Reason: "encrypted private keys are not supported"}
+	default:
+		return nil, fmt.Errorf("unexpected %s block in private key file", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, &UnsupportedKeyError{Key: "private", Reason: fmt.Sprintf("%T", key)}
+	}
+
+	if err := checkPublicKey("private", signer.Public()); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// checkPublicKey checks that Qiniu accepts the key type and size
+func checkPublicKey(which string, pub crypto.PublicKey) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return &UnsupportedKeyError{Key: which, Reason: fmt.Sprintf("RSA key of %d bits, at least %d required", pub.N.BitLen(), minRSABits)}
+		}
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() && pub.Curve != elliptic.P384() {
+			return &UnsupportedKeyError{Key: which, Reason: fmt.Sprintf("ECDSA curve %s, only P-256 and P-384 are supported", pub.Curve.Params().Name)}
+		}
+	case ed25519.PublicKey:
+		return &UnsupportedKeyError{Key: which, Reason: "Ed25519 keys are not supported"}
+	default:
+		return &UnsupportedKeyError{Key: which, Reason: fmt.Sprintf("%T", pub)}
+	}
+	return nil
+}
+
+// checkKeyPair checks that the private key belongs to the certificate
+func checkKeyPair(leaf *x509.Certificate, key crypto.Signer) error {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return &KeyMismatchError{Subject: leaf.Subject.CommonName}
+	}
+	return nil
+}
+
+// checkChain checks that every certificate is issued by the next one, and that the
+// last one is a root or issued by a root trusted by the system
+func checkChain(chain []*x509.Certificate) error {
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return &ChainError{Index: i + 1, Reason: fmt.Sprintf("%s is not the issuer of %s, the chain is misordered or contains an unrelated certificate",
+				chain[i+1].Subject.CommonName, chain[i].Subject.CommonName)}
+		}
+	}
+
+	last := chain[len(chain)-1]
+	if last.CheckSignatureFrom(last) == nil {
+		// Self-signed root included in the chain
+		return nil
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		// Completeness cannot be checked without the system roots
+		return nil
+	}
+
+	_, err = last.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: last.NotBefore.Add(time.Second),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		return &ChainError{Index: len(chain) - 1, Reason: fmt.Sprintf("issuer %s of %s is missing, the chain is incomplete",
+			last.Issuer.CommonName, last.Subject.CommonName)}
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package certcheck
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate issued for tests along with its key
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// newTestCert issues a certificate with the key, valid from notBefore to notAfter, signed by
+// parent or self-signed if parent is nil. The certificate is a CA if names is empty.
+func newTestCert(t *testing.T, cn string, names []string, key crypto.Signer, parent *testCert, notBefore, notAfter time.Time) *testCert {
+	t.Helper()
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		t.Fatalf("serial: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     names,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if len(names) == 0 {
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.BasicConstraintsValid = true
+		template.IsCA = true
+	}
+
+	issuer, issuerKey := template, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+
+	return &testCert{cert: cert, key: key}
+}
+
+// newECKey generates a P-256 key
+func newECKey(t *testing.T) crypto.Signer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+// encodeChain encodes certificates as a PEM bundle
+func encodeChain(certs ...*testCert) []byte {
+	var bundle []byte
+	for _, c := range certs {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})...)
+	}
+	return bundle
+}
+
+// encodeKey encodes a private key as PKCS #8 PEM
+func encodeKey(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	notBefore, notAfter := now.Add(-time.Hour), now.AddDate(0, 0, 90)
+
+	root := newTestCert(t, "Test Root", nil, newECKey(t), nil, notBefore, notAfter)
+	intermediate := newTestCert(t, "Test Intermediate", nil, newECKey(t), root, notBefore, notAfter)
+	leaf := newTestCert(t, "cdn.example.com", []string{"cdn.example.com"}, newECKey(t), intermediate, notBefore, notAfter)
+	wildcard := newTestCert(t, "*.example.com", []string{"*.example.com"}, newECKey(t), root, notBefore, notAfter)
+	expired := newTestCert(t, "cdn.example.com", []string{"cdn.example.com"}, newECKey(t), root, now.AddDate(0, 0, -90), now.Add(-time.Hour))
+	notYetValid := newTestCert(t, "cdn.example.com", []string{"cdn.example.com"}, newECKey(t), root, now.Add(time.Hour), notAfter)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	rsa1024 := newTestCert(t, "cdn.example.com", []string{"cdn.example.com"}, rsaKey, root, notBefore, notAfter)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ed := newTestCert(t, "cdn.example.com", []string{"cdn.example.com"}, edKey, root, notBefore, notAfter)
+
+	_, systemRootsErr := x509.SystemCertPool()
+
+	tests := []struct {
+		name    string
+		certPEM []byte
+		keyPEM  []byte
+		domain  string
+		check   func(error) bool // nil if the certificate is valid
+		system  bool             // needs the system roots
+	}{
+		{name: "valid chain", certPEM: encodeChain(leaf, intermediate, root), keyPEM: encodeKey(t, leaf.key), domain: "cdn.example.com"},
+		{name: "no domain", certPEM: encodeChain(leaf, intermediate, root), keyPEM: encodeKey(t, leaf.key)},
+		{name: "wildcard domain", certPEM: encodeChain(wildcard, root), keyPEM: encodeKey(t, wildcard.key), domain: ".example.com"},
+		{
+			name: "mismatched key", certPEM: encodeChain(leaf, intermediate, root), keyPEM: encodeKey(t, newECKey(t)), domain: "cdn.example.com",
+			check: func(err error) bool {
+				var e *KeyMismatchError
+				return errors.As(err, &e) && e.Subject == "cdn.example.com"
+			},
+		},
+		{
+			name: "RSA-1024", certPEM: encodeChain(rsa1024, root), keyPEM: encodeKey(t, rsaKey), domain: "cdn.example.com",
+			check: func(err error) bool {
+				var e *UnsupportedKeyError
+				return errors.As(err, &e) && e.Key == "certificate" && strings.Contains(e.Reason, "1024")
+			},
+		},
+		{
+			name: "Ed25519", certPEM: encodeChain(ed, root), keyPEM: encodeKey(t, edKey), domain: "cdn.example.com",
+			check: func(err error) bool { var e *UnsupportedKeyError; return errors.As(err, &e) && e.Key == "certificate" },
+		},
+		{
+			name: "Ed25519 private key", certPEM: encodeChain(leaf, intermediate, root), keyPEM: encodeKey(t, edKey), domain: "cdn.example.com",
+			check: func(err error) bool { var e *UnsupportedKeyError; return errors.As(err, &e) && e.Key == "private" },
+		},
+		{
+			name: "reversed chain", certPEM: encodeChain(leaf, root, intermediate), keyPEM: encodeKey(t, leaf.key), domain: "cdn.example.com",
+			check: func(err error) bool { var e *ChainError; return errors.As(err, &e) && e.Index == 1 },
+		},
+		{
+			name: "unrelated certificate", certPEM: encodeChain(leaf, wildcard), keyPEM: encodeKey(t, leaf.key), domain: "cdn.example.com",
+			check: func(err error) bool { var e *ChainError; return errors.As(err, &e) && e.Index == 1 },
+		},
+		{
+			name: "missing intermediate", certPEM: encodeChain(leaf), keyPEM: encodeKey(t, leaf.key), domain: "cdn.example.com",
+			check:  func(err error) bool { var e *ChainError; return errors.As(err, &e) && e.Index == 0 },
+			system: true,
+		},
+		{
+			name: "missing root", certPEM: encodeChain(leaf, intermediate), keyPEM: encodeKey(t, leaf.key), domain: "cdn.example.com",
+			check:  func(err error) bool { var e *ChainError; return errors.As(err, &e) && e.Index == 1 },
+			system: true,
+		},
+		{
+			name: "expired", certPEM: encodeChain(expired, root), keyPEM: encodeKey(t, expired.key), domain: "cdn.example.com",
+			check: func(err error) bool {
+				var e *ValidityError
+				return errors.As(err, &e) && e.Expired && strings.Contains(e.Error(), "expired")
+			},
+		},
+		{
+			name: "not yet valid", certPEM: encodeChain(notYetValid, root), keyPEM: encodeKey(t, notYetValid.key), domain: "cdn.example.com",
+			check: func(err error) bool {
+				var e *ValidityError
+				return errors.As(err, &e) && !e.Expired && strings.Contains(e.Error(), "not valid before")
+			},
+		},
+		{
+			name: "apex not covered by wildcard", certPEM: encodeChain(wildcard, root), keyPEM: encodeKey(t, wildcard.key), domain: "example.com",
+			check: func(err error) bool {
+				var e *DomainMismatchError
+				return errors.As(err, &e) && e.Domain == "example.com"
+			},
+		},
+		{
+			name: "wildcard domain needs wildcard certificate", certPEM: encodeChain(leaf, intermediate, root), keyPEM: encodeKey(t, leaf.key), domain: ".example.com",
+			check: func(err error) bool { var e *DomainMismatchError; return errors.As(err, &e) },
+		},
+		{
+			name: "no certificate", certPEM: []byte("not a certificate"), keyPEM: encodeKey(t, leaf.key),
+			check: func(err error) bool { return err != nil },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.system && systemRootsErr != nil {
+				t.Skipf("system roots unavailable: %v", systemRootsErr)
+			}
+
+			err := Validate(tt.certPEM, tt.keyPEM, tt.domain)
+			switch {
+			case tt.check == nil && err != nil:
+				t.Errorf("Validate: %v, want no error", err)
+			case tt.check != nil && !tt.check(err):
+				t.Errorf("Validate: got %v (%T)", err, err)
+			}
+		})
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		names  []string
+		domain string
+		want   bool
+	}{
+		{[]string{"cdn.example.com"}, "cdn.example.com", true},
+		{[]string{"cdn.example.com"}, "CDN.Example.com.", true},
+		{[]string{"cdn.example.com"}, "www.example.com", false},
+		{[]string{"*.example.com"}, "cdn.example.com", true},
+		{[]string{"*.example.com"}, "example.com", false},
+		{[]string{"*.example.com"}, "a.cdn.example.com", false},
+		{[]string{"*.example.com"}, ".example.com", true},
+		{[]string{"*.cdn.example.com"}, ".example.com", false},
+		{[]string{"cdn.example.com"}, ".example.com", false},
+		{[]string{"example.com"}, ".example.com", false},
+		{[]string{"example.com", "*.example.com"}, "example.com", true},
+		{nil, "cdn.example.com", true}, // Common name fallback
+	}
+
+	for _, tt := range tests {
+		cert := &x509.Certificate{DNSNames: tt.names, Subject: pkix.Name{CommonName: "cdn.example.com"}}
+		if got := Covers(cert, tt.domain); got != tt.want {
+			t.Errorf("Covers(%v, %q) = %t, want %t", tt.names, tt.domain, got, tt.want)
+		}
+	}
+}
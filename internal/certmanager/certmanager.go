@@ -5,17 +5,38 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/WqyJh/qiniu-ssl/internal/aliyundns"
+	"github.com/WqyJh/qiniu-ssl/internal/caa"
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
 )
 
+// CAAIdentity is the issuer domain name of Let's Encrypt used in CAA records
+const CAAIdentity = "letsencrypt.org"
+
+const (
+	// caaTimeout bounds the wait for an added CAA record to be served
+	caaTimeout = 5 * time.Minute
+
+	// caaInterval is the interval between checks of an added CAA record
+	caaInterval = 10 * time.Second
+)
+
+// CAAProvisioner is implemented by DNS providers that can add CAA records
+type CAAProvisioner interface {
+	AddCAARecord(name, tag, issuer string) error
+}
+
 // CertManager handles Let's Encrypt SSL certificate operations
 type CertManager struct {
 	Domain   string
@@ -74,7 +95,19 @@ func NewCertManager(domain, email, cacheDir string) (*CertManager, error) {
 }
 
 // RequestCertificate requests a new certificate from Let's Encrypt using DNS-01 challenge
-func (cm *CertManager) RequestCertificate(aliyunAccessKey, aliyunSecretKey, aliyunRegion string) error {
+// The given provider is used to present the challenge TXT records.
+// If provisionCAA is true and the CAA records of the domain do not authorize Let's Encrypt,
+// a CAA record is added through the provider.
+func (cm *CertManager) RequestCertificate(provider challenge.Provider, provisionCAA bool) error {
+	if provider == nil {
+		return fmt.Errorf("DNS provider cannot be nil")
+	}
+
+	// Fail fast if CAA records do not allow Let's Encrypt to issue the certificate
+	if err := cm.checkCAA(provider, provisionCAA); err != nil {
+		return err
+	}
+
 	// Create a new user key
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -97,12 +130,6 @@ func (cm *CertManager) RequestCertificate(aliyunAccessKey, aliyunSecretKey, aliy
 		return fmt.Errorf("failed to create ACME client: %v", err)
 	}
 
-	// Create a new Aliyun DNS provider
-	provider, err := aliyundns.NewDNSProvider(aliyunAccessKey, aliyunSecretKey, aliyunRegion)
-	if err != nil {
-		return fmt.Errorf("failed to create DNS provider: %v", err)
-	}
-
 	// Set the DNS provider
 	err = client.Challenge.SetDNS01Provider(provider)
 	if err != nil {
@@ -118,7 +145,7 @@ func (cm *CertManager) RequestCertificate(aliyunAccessKey, aliyunSecretKey, aliy
 
 	// Request a certificate
 	request := certificate.ObtainRequest{
-		Domains: []string{cm.Domain},
+		Domains: []string{acmeIdentifier(cm.Domain)},
 		Bundle:  true,
 	}
 	certificates, err := client.Certificate.Obtain(request)
@@ -139,6 +166,58 @@ func (cm *CertManager) RequestCertificate(aliyunAccessKey, aliyunSecretKey, aliy
 	return nil
 }
 
+// checkCAA verifies that the CAA records of the domain authorize Let's Encrypt
+func (cm *CertManager) checkCAA(provider challenge.Provider, provisionCAA bool) error {
+	err := caa.Check(acmeIdentifier(cm.Domain), CAAIdentity)
+	if err == nil {
+		return nil
+	}
+
+	// No record can be added to lift a critical unknown tag
+	var critical *caa.CriticalTagError
+	if errors.As(err, &critical) {
+		return err
+	}
+
+	var blocked *caa.BlockedError
+	if !errors.As(err, &blocked) {
+		// Let the CA decide if the records cannot be resolved locally
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+
+	if !provisionCAA {
+		return err
+	}
+
+	provisioner, ok := provider.(CAAProvisioner)
+	if !ok {
+		return fmt.Errorf("%v, and the DNS provider cannot add CAA records", err)
+	}
+
+	log.Printf("Adding CAA record %s 0 %s %q", blocked.Name, blocked.Tag, blocked.Issuer)
+	if err := provisioner.AddCAARecord(blocked.Name, blocked.Tag, blocked.Issuer); err != nil {
+		return fmt.Errorf("failed to add CAA record: %v", err)
+	}
+
+	// The CA checks the records when issuing, it must see the new one
+	log.Printf("Waiting up to %s for the CAA record to be served...", caaTimeout)
+	if err := caa.Wait(blocked.Domain, CAAIdentity, caaTimeout, caaInterval); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// acmeIdentifier returns the ACME identifier of a Qiniu domain name. Qiniu names wildcard
+// domains .example.com, which are *.example.com for the CA.
+func acmeIdentifier(domain string) string {
+	if strings.HasPrefix(domain, ".") {
+		return "*" + domain
+	}
+	return domain
+}
+
 // GetCertificatePaths returns the paths to the certificate and key files
 func (cm *CertManager) GetCertificatePaths() (certPath, keyPath string) {
 	return cm.certPath, cm.keyPath
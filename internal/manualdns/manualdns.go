@@ -0,0 +1,114 @@
+package manualdns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+// DNSProvider implements the challenge.Provider interface by asking an operator
+// to create the TXT records by hand
+type DNSProvider struct {
+	poll         bool
+	timeout      time.Duration
+	pollInterval time.Duration
+	in           *bufio.Reader
+	out          io.Writer
+}
+
+// NewDNSProvider returns a new manual DNS provider.
+// If poll is true, the provider waits until the TXT record is visible in DNS
+// instead of waiting for the operator to press Enter.
+func NewDNSProvider(poll bool, timeout time.Duration) (*DNSProvider, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("Manual DNS: timeout must be greater than 0")
+	}
+
+	return &DNSProvider{
+		poll:         poll,
+		timeout:      timeout,
+		pollInterval: 10 * time.Second,
+		in:           bufio.NewReader(os.Stdin),
+		out:          os.Stdout,
+	}, nil
+}
+
+// Present prints the TXT record to create and waits until it is in place
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+
+	fmt.Fprintf(d.out, "Please create the following TXT record for %s:\n", domain)
+	fmt.Fprintf(d.out, "  Name:  %s\n", dns01.UnFqdn(info.EffectiveFQDN))
+	fmt.Fprintf(d.out, "  Type:  TXT\n")
+	fmt.Fprintf(d.out, "  Value: %s\n", info.Value)
+
+	if d.poll {
+		return d.waitForRecord(info.EffectiveFQDN, info.Value)
+	}
+
+	fmt.Fprintf(d.out, "Press 'Enter' when the record has been created\n")
+	if _, err := d.in.ReadString('\n'); err != nil {
+		return fmt.Errorf("Manual DNS: %v", err)
+	}
+
+	return nil
+}
+
+// CleanUp tells the operator that the TXT record can be removed
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+
+	fmt.Fprintf(d.out, "The TXT record %s can now be removed\n", dns01.UnFqdn(info.EffectiveFQDN))
+
+	return nil
+}
+
+// Timeout returns the timeout and interval used by the ACME client
+// when checking for DNS record propagation
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.timeout, d.pollInterval
+}
+
+// waitForRecord polls DNS until the TXT record with the expected value is visible
+func (d *DNSProvider) waitForRecord(fqdn, value string) error {
+	name := dns01.UnFqdn(fqdn)
+	deadline := time.Now().Add(d.timeout)
+
+	fmt.Fprintf(d.out, "Waiting up to %s for the record to appear in DNS...\n", d.timeout)
+	for {
+		if found, err := lookupTXT(name, value); err == nil && found {
+			fmt.Fprintf(d.out, "TXT record %s found\n", name)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Manual DNS: timed out waiting for TXT record %s", name)
+		}
+		time.Sleep(d.pollInterval)
+	}
+}
+
+// lookupTXT checks whether name has a TXT record with the given value
+func lookupTXT(name, value string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	for _, record := range records {
+		if record == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,162 @@
+package qiniuapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// certListPageSize is the page size used when listing all certificates
+const certListPageSize = 100
+
+// CertificateListResponse represents a page of certificates
+type CertificateListResponse struct {
+	Marker string            `json:"marker"`
+	Certs  []CertificateInfo `json:"certs"`
+}
+
+// ListCertificates retrieves a page of at most limit certificates starting at marker.
+// It returns the marker of the next page, which is empty on the last page.
+func (q *QiniuClient) ListCertificates(ctx context.Context, marker string, limit int) ([]CertificateInfo, string, error) {
+	query := url.Values{}
+	query.Set("marker", marker)
+	query.Set("limit", strconv.Itoa(limit))
+
+	respBody, err := q.doRequest(ctx, http.MethodGet, q.baseURL+"/sslcert?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list certificates: %w", err)
+	}
+
+	var list CertificateListResponse
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, "", fmt.Errorf("failed to parse certificate list: %w", err)
+	}
+	for i := range list.Certs {
+		list.Certs[i].parseMarker()
+	}
+
+	return list.Certs, list.Marker, nil
+}
+
+// ListAllCertificates retrieves all certificates of the account
+func (q *QiniuClient) ListAllCertificates(ctx context.Context) ([]CertificateInfo, error) {
+	var certs []CertificateInfo
+	marker := ""
+	for {
+		page, next, err := q.ListCertificates(ctx, marker, certListPageSize)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, page...)
+
+		if next == "" || len(page) == 0 {
+			return certs, nil
+		}
+		marker = next
+	}
+}
+
+// CertificateFingerprints lists all certificates of the account and returns their IDs by
+// SHA-256 fingerprint, to find the uploaded certificate identical to a local one
+func (q *QiniuClient) CertificateFingerprints(ctx context.Context) (map[string]string, error) {
+	certs, err := q.ListAllCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprints := make(map[string]string, len(certs))
+	for _, cert := range certs {
+		found := cert.Marker
+		if found == nil && cert.Ca != "" {
+			// Not uploaded by this tool, derive the marker from the certificate itself
+			found, _ = NewCertificateMarker([]byte(cert.Ca))
+		}
+		if found == nil {
+			continue
+		}
+
+		fingerprints[found.Fingerprint] = cert.ID
+	}
+
+	return fingerprints, nil
+}
+
+// DeleteCertificate deletes a certificate by ID.
+// Qiniu refuses to delete certificates that are bound to a domain.
+func (q *QiniuClient) DeleteCertificate(ctx context.Context, certID string) error {
+	url := fmt.Sprintf("%s/sslcert/%s", q.baseURL, certID)
+	if _, err := q.doRequest(ctx, http.MethodDelete, url, nil); err != nil {
+		return fmt.Errorf("failed to delete certificate: %w", err)
+	}
+
+	return nil
+}
+
+// GetCertificateBindings returns the HTTPS domains of the account, CDN, Kodo bucket and
+// live-streaming domains alike, grouped by the ID of the certificate bound to them.
+// Kodo and live-streaming domains are skipped if the key may not use those services,
+// or the account has not enabled them.
+func (q *QiniuClient) GetCertificateBindings(ctx context.Context) (map[string][]string, error) {
+	domains, err := q.ListAllDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := make(map[string][]string)
+	for _, domain := range domains {
+		if domain.Protocol != "https" {
+			continue
+		}
+
+		// The domain list does not include the HTTPS configuration
+		info, err := q.GetDomainInfo(ctx, domain.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.HTTPS != nil && info.HTTPS.CertID != "" {
+			bindings[info.HTTPS.CertID] = append(bindings[info.HTTPS.CertID], domain.Name)
+		}
+	}
+
+	// Certificates are shared with the custom domains of Kodo buckets
+	bucketDomains, err := q.ListAllBucketDomains(ctx)
+	if err != nil {
+		if !IsAccessDenied(err) && !IsNotFound(err) {
+			return nil, err
+		}
+		log.Printf("Skipping Kodo bucket domains: %v", err)
+	}
+	for _, domain := range bucketDomains {
+		if domain.CertID != "" {
+			bindings[domain.CertID] = append(bindings[domain.CertID], domain.Domain)
+		}
+	}
+
+	// And with the domains of live-streaming hubs
+	hubDomains, err := q.ListAllPiliDomains(ctx)
+	if err != nil {
+		if !IsAccessDenied(err) {
+			return nil, err
+		}
+		log.Printf("Skipping live-streaming domains: %v", err)
+	}
+	for hub, domains := range hubDomains {
+		for _, domain := range domains {
+			// The domain list may not include the certificate
+			info, err := q.GetPiliDomain(ctx, hub, domain.Domain)
+			if err != nil {
+				return nil, err
+			}
+			if info.CertID != "" {
+				bindings[info.CertID] = append(bindings[info.CertID], domain.Domain)
+			}
+		}
+	}
+
+	return bindings, nil
+}
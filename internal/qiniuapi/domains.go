@@ -0,0 +1,171 @@
+package qiniuapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// domainListPageSize is the page size used when listing all domains
+const domainListPageSize = 100
+
+// DomainListResponse represents a page of domains
+type DomainListResponse struct {
+	Marker  string       `json:"marker"`
+	Domains []DomainInfo `json:"domains"`
+}
+
+// ListDomains retrieves a page of at most limit CDN domains starting at marker.
+// It returns the marker of the next page, which is empty on the last page.
+// The listed domains do not include the HTTPS configuration, use GetDomainInfo for it.
+func (q *QiniuClient) ListDomains(ctx context.Context, marker string, limit int) ([]DomainInfo, string, error) {
+	query := url.Values{}
+	query.Set("marker", marker)
+	query.Set("limit", strconv.Itoa(limit))
+
+	respBody, err := q.doRequest(ctx, http.MethodGet, q.baseURL+"/domain?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list domains: %w", err)
+	}
+
+	var list DomainListResponse
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, "", fmt.Errorf("failed to parse domain list: %w", err)
+	}
+
+	return list.Domains, list.Marker, nil
+}
+
+// ListAllDomains retrieves all CDN domains of the account. GetCertificateBindings uses it to
+// find the domains bound to each certificate, for the --bound and --unbound filters of certs list.
+func (q *QiniuClient) ListAllDomains(ctx context.Context) ([]DomainInfo, error) {
+	var domains []DomainInfo
+	marker := ""
+	for {
+		page, next, err := q.ListDomains(ctx, marker, domainListPageSize)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, page...)
+
+		if next == "" || len(page) == 0 {
+			return domains, nil
+		}
+		marker = next
+	}
+}
+
+// CreateDomainRequest represents the configuration of a new CDN domain
+type CreateDomainRequest struct {
+	Type     string      `json:"type"`
+	Platform string      `json:"platform"` // web, download, vod or dynamic
+	GeoCover string      `json:"geoCover"` // china, foreign or global
+	Protocol string      `json:"protocol"` // http or https
+	Source   *SourceInfo `json:"source"`   // Qiniu reads the test URL path from the source
+	Cache    *CacheInfo  `json:"cache,omitempty"`
+	HTTPS    *HTTPSInfo  `json:"https,omitempty"` // Required if Protocol is https
+}
+
+// CreateDomain creates a CDN domain. Qiniu provisions the domain asynchronously,
+// use WaitForDomainOperation to wait until it is online.
+func (q *QiniuClient) CreateDomain(ctx context.Context, name string, req CreateDomainRequest) error {
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/domain/%s", q.baseURL, name)
+	if _, err := q.doRequest(ctx, http.MethodPost, url, reqBody); err != nil {
+		return fmt.Errorf("failed to create domain: %w", err)
+	}
+
+	return nil
+}
+
+// SourceInfo represents the origin configuration of a domain
+type SourceInfo struct {
+	SourceType        string           `json:"sourceType"` // domain, ip, advanced or qiniuBucket
+	SourceHost        string           `json:"sourceHost,omitempty"`
+	SourceIPs         []string         `json:"sourceIPs,omitempty"`
+	SourceDomain      string           `json:"sourceDomain,omitempty"`
+	SourceQiniuBucket string           `json:"sourceQiniuBucket,omitempty"`
+	SourceURLScheme   string           `json:"sourceURLScheme,omitempty"` // http, https or empty to follow the request
+	AdvancedSources   []AdvancedSource `json:"advancedSources,omitempty"`
+	TestURLPath       string           `json:"testURLPath,omitempty"`
+}
+
+// UsesHTTPS reports whether Qiniu fetches from the origin over HTTPS for a domain with
+// the given protocol, which the origin follows unless SourceURLScheme is set
+func (s *SourceInfo) UsesHTTPS(protocol string) bool {
+	if s.SourceURLScheme != "" {
+		return s.SourceURLScheme == "https"
+	}
+	return protocol == "https"
+}
+
+// Addrs returns the addresses of the origin servers, with an optional port.
+// Qiniu bucket origins have none.
+func (s *SourceInfo) Addrs() []string {
+	switch s.SourceType {
+	case "domain":
+		return []string{s.SourceDomain}
+	case "ip":
+		return s.SourceIPs
+	case "advanced":
+		addrs := make([]string, len(s.AdvancedSources))
+		for i, source := range s.AdvancedSources {
+			addrs[i] = source.Addr
+		}
+		return addrs
+	default:
+		return nil
+	}
+}
+
+// AdvancedSource represents an origin address of the advanced source type
+type AdvancedSource struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight"`
+	Backup bool   `json:"backup"`
+}
+
+// CacheInfo represents the cache configuration of a domain
+type CacheInfo struct {
+	CacheControls []CacheControl `json:"cacheControls,omitempty"`
+	IgnoreParam   bool           `json:"ignoreParam"`
+}
+
+// CacheControl represents a cache rule
+type CacheControl struct {
+	Time     int    `json:"time"`
+	TimeUnit int    `json:"timeunit"`
+	Type     string `json:"type"` // all, path, suffix or follow
+	Rule     string `json:"rule"`
+}
+
+// RefererACL represents the referer access control of a domain
+type RefererACL struct {
+	RefererType   string   `json:"refererType"` // black, white or empty for none
+	RefererValues []string `json:"refererValues,omitempty"`
+	NullReferer   bool     `json:"nullReferer"`
+}
+
+// IPACL represents the IP access control of a domain
+type IPACL struct {
+	IPACLType   string   `json:"ipACLType"` // black, white or empty for none
+	IPACLValues []string `json:"ipACLValues,omitempty"`
+}
+
+// parseDomainTime parses the time format used by the domain API, returning zero if it cannot be parsed
+func parseDomainTime(value string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
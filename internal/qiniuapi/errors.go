@@ -0,0 +1,130 @@
+package qiniuapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Qiniu API error codes
+const (
+	CodeInvalidArgs      = 400000
+	CodeInvalidCert      = 400031
+	CodeDomainNotHTTPS   = 400033
+	CodeDomainAlreadySSL = 400034
+	CodeDomainProcessing = 400045
+	CodeCertDomainMatch  = 400046
+	CodeCertInUse        = 400047
+	CodeDomainNotFound   = 404001
+	CodeCertNotFound     = 404002
+)
+
+// APIError is returned when the Qiniu API responds with an error
+type APIError struct {
+	StatusCode int    // HTTP status code
+	Code       int    // Qiniu error code
+	Message    string // Qiniu error message
+	RequestID  string // Qiniu request ID (X-Reqid header)
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("API error: %s (status %d", e.Message, e.StatusCode)
+	if e.Code != 0 {
+		msg += fmt.Sprintf(", code %d", e.Code)
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(", request ID %s", e.RequestID)
+	}
+	return msg + ")"
+}
+
+// DomainOperationError is returned when Qiniu reports that a domain operation failed
+type DomainOperationError struct {
+	Domain        string
+	OperationType string
+	State         string
+	Description   string
+}
+
+func (e *DomainOperationError) Error() string {
+	msg := fmt.Sprintf("operation %s on domain %s %s", e.OperationType, e.Domain, e.State)
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// IsNotFound reports whether err means the domain or certificate does not exist
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusNotFound ||
+		apiErr.Code == CodeDomainNotFound || apiErr.Code == CodeCertNotFound
+}
+
+// IsCertNotFound reports whether err means the certificate does not exist, unlike
+// IsNotFound, which also matches missing domains
+func IsCertNotFound(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == CodeCertNotFound
+}
+
+// IsAccessDenied reports whether err means the key is not allowed to use the API,
+// e.g. a key restricted to the CDN calling the Kodo or live-streaming APIs
+func IsAccessDenied(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+}
+
+// IsDomainProcessing reports whether err means the domain is being processed
+// by a previous operation and the request should be tried again later
+func IsDomainProcessing(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == CodeDomainProcessing ||
+		strings.Contains(strings.ToLower(apiErr.Message), "processing")
+}
+
+// IsInvalidCertificate reports whether err means Qiniu rejected the certificate
+func IsInvalidCertificate(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == CodeInvalidCert || apiErr.Code == CodeCertDomainMatch
+}
+
+// IsRateLimited reports whether err means the request was rate limited
+func IsRateLimited(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests
+}
+
+// IsRetryable reports whether a request that failed with err may succeed if retried
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// Network errors
+		return true
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,167 @@
+package qiniuapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// BucketDomain is a custom domain bound directly to a Kodo bucket, without CDN
+type BucketDomain struct {
+	Domain     string `json:"domain"`
+	Bucket     string `json:"tbl"`
+	CertID     string `json:"certid,omitempty"` // Bound certificate, empty if HTTPS is not enabled
+	CreateTime int64  `json:"ctime"`
+	UpdateTime int64  `json:"utime"`
+}
+
+// ListBuckets retrieves the names of all Kodo buckets of the account
+func (q *QiniuClient) ListBuckets(ctx context.Context) ([]string, error) {
+	respBody, err := q.doRequest(ctx, http.MethodGet, q.ucURL+"/buckets", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	var buckets []string
+	if err := json.Unmarshal(respBody, &buckets); err != nil {
+		return nil, fmt.Errorf("failed to parse bucket list: %w", err)
+	}
+
+	return buckets, nil
+}
+
+// ListBucketDomains retrieves the custom domains bound to a Kodo bucket
+func (q *QiniuClient) ListBucketDomains(ctx context.Context, bucket string) ([]BucketDomain, error) {
+	query := url.Values{}
+	query.Set("tbl", bucket)
+
+	respBody, err := q.doRequest(ctx, http.MethodGet, q.ucURL+"/v3/domains?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains of bucket %s: %w", bucket, err)
+	}
+
+	var domains []BucketDomain
+	if err := json.Unmarshal(respBody, &domains); err != nil {
+		return nil, fmt.Errorf("failed to parse bucket domain list: %w", err)
+	}
+
+	return domains, nil
+}
+
+// ListAllBucketDomains retrieves the custom domains of all Kodo buckets of the account
+func (q *QiniuClient) ListAllBucketDomains(ctx context.Context) ([]BucketDomain, error) {
+	buckets, err := q.ListBuckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []BucketDomain
+	for _, bucket := range buckets {
+		page, err := q.ListBucketDomains(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, page...)
+	}
+
+	return domains, nil
+}
+
+// GetBucketDomain retrieves a custom domain of a Kodo bucket.
+// It returns an error satisfying IsNotFound if the domain is not bound to the bucket.
+func (q *QiniuClient) GetBucketDomain(ctx context.Context, bucket, domain string) (*BucketDomain, error) {
+	domains, err := q.ListBucketDomains(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range domains {
+		if domains[i].Domain == domain {
+			return &domains[i], nil
+		}
+	}
+
+	return nil, &APIError{
+		StatusCode: http.StatusNotFound,
+		Code:       CodeDomainNotFound,
+		Message:    fmt.Sprintf("domain %s is not bound to bucket %s", domain, bucket),
+	}
+}
+
+// BindBucketDomainCertificate enables HTTPS on a custom domain of a Kodo bucket with
+// the certificate, or replaces its certificate. Unlike CDN domains, the change is immediate.
+func (q *QiniuClient) BindBucketDomainCertificate(ctx context.Context, domain, certID string) error {
+	reqBody, err := json.Marshal(struct {
+		Domain string `json:"domain"`
+		CertID string `json:"certid"`
+	}{
+		Domain: domain,
+		CertID: certID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.doRequest(ctx, http.MethodPost, q.ucURL+"/cert/bind", reqBody); err != nil {
+		return fmt.Errorf("failed to bind certificate to bucket domain: %w", err)
+	}
+
+	return nil
+}
+
+// CheckBucketCertificates checks the certificates bound to custom domains of Kodo buckets,
+// given as a map of domain to bucket, like CheckCertificates. The domains of each bucket
+// are listed once.
+func (q *QiniuClient) CheckBucketCertificates(ctx context.Context, domains map[string]string, thresholdDays, concurrency int) (map[string]*CertificateStatus, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultCheckConcurrency
+	}
+
+	byBucket := make(map[string][]string)
+	for domain, bucket := range domains {
+		byBucket[bucket] = append(byBucket[bucket], domain)
+	}
+
+	buckets := make([]string, 0, len(byBucket))
+	for bucket := range byBucket {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	statuses := make(map[string]*CertificateStatus, len(domains))
+	certIDs := make(map[string]string)
+	for _, bucket := range buckets {
+		listed, err := q.ListBucketDomains(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		bound := make(map[string]BucketDomain, len(listed))
+		for _, d := range listed {
+			bound[d.Domain] = d
+		}
+
+		for _, domain := range byBucket[bucket] {
+			status := &CertificateStatus{Domain: domain, NeedsRenewal: true}
+			statuses[domain] = status
+
+			d, ok := bound[domain]
+			switch {
+			case !ok:
+				status.Err = fmt.Errorf("domain not bound to bucket %s", bucket)
+			case d.CertID == "":
+				status.Err = fmt.Errorf("domain does not have HTTPS enabled or certificate bound")
+			default:
+				certIDs[domain] = d.CertID
+			}
+		}
+	}
+
+	if err := q.checkExpiry(ctx, statuses, certIDs, thresholdDays, concurrency); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
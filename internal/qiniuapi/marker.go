@@ -0,0 +1,101 @@
+package qiniuapi
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CertificateMarker is the ownership metadata written into the description
+// of certificates uploaded by this tool
+type CertificateMarker struct {
+	Issuer      string    // Common name of the issuing CA
+	Serial      string    // Serial number in hex
+	Fingerprint string    // SHA-256 fingerprint of the leaf certificate in hex
+	IssuedAt    time.Time // Start of the validity period
+}
+
+// NewCertificateMarker creates the marker of the leaf certificate in a PEM bundle
+func NewCertificateMarker(certPEM []byte) (*CertificateMarker, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in PEM data")
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertificateMarker{
+		Issuer:      leaf.Issuer.CommonName,
+		Serial:      fmt.Sprintf("%x", leaf.SerialNumber),
+		Fingerprint: Fingerprint(leaf),
+		IssuedAt:    leaf.NotBefore.UTC(),
+	}, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate in hex
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// String formats the marker as
+// qiniu-ssl;ca=<issuer>;serial=<serial>;sha256=<fingerprint>;issued=<RFC 3339 time>
+func (m *CertificateMarker) String() string {
+	return fmt.Sprintf("%s;ca=%s;serial=%s;sha256=%s;issued=%s", ToolTag,
+		strings.ReplaceAll(m.Issuer, ";", " "), m.Serial, m.Fingerprint, m.IssuedAt.Format(time.RFC3339))
+}
+
+// ParseCertificateMarker parses a marker written by CertificateMarker.String.
+// It returns false if the description was not written by this tool, or lacks a well-formed
+// serial number or fingerprint, which identify the certificate.
+func ParseCertificateMarker(description string) (*CertificateMarker, bool) {
+	fields := strings.Split(description, ";")
+	if fields[0] != ToolTag {
+		return nil, false
+	}
+
+	marker := &CertificateMarker{}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "ca":
+			marker.Issuer = value
+		case "serial":
+			marker.Serial = value
+		case "sha256":
+			marker.Fingerprint = value
+		case "issued":
+			marker.IssuedAt, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+
+	if !isHex(marker.Serial) || len(marker.Fingerprint) != 2*sha256.Size || !isHex(marker.Fingerprint) {
+		return nil, false
+	}
+
+	return marker, true
+}
+
+// isHex checks whether s is a non-empty lowercase hex string, as written by this tool
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package qiniuapi_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+)
+
+func TestCertificateMarkerRoundTrip(t *testing.T) {
+	certPEM, _ := newTestCertificate(t, "cdn.example.com")
+	block, _ := pem.Decode(certPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marker, err := qiniuapi.NewCertificateMarker(certPEM)
+	if err != nil {
+		t.Fatalf("NewCertificateMarker: %v", err)
+	}
+	if marker.Serial != fmt.Sprintf("%x", leaf.SerialNumber) || marker.Fingerprint != qiniuapi.Fingerprint(leaf) {
+		t.Errorf("marker %+v does not identify the certificate", marker)
+	}
+
+	parsed, ok := qiniuapi.ParseCertificateMarker(marker.String())
+	if !ok {
+		t.Fatalf("ParseCertificateMarker(%q) failed", marker.String())
+	}
+	if *parsed != *marker {
+		t.Errorf("parsed %+v, want %+v", parsed, marker)
+	}
+
+	// Semicolons separate the fields, so they cannot appear in the issuer
+	marker.Issuer = "Example CA; Test"
+	if parsed, ok := qiniuapi.ParseCertificateMarker(marker.String()); !ok || parsed.Issuer != "Example CA  Test" {
+		t.Errorf("parsed %+v, want the issuer without semicolons", parsed)
+	}
+
+	if _, err := qiniuapi.NewCertificateMarker([]byte("not a certificate")); err == nil {
+		t.Error("NewCertificateMarker accepted invalid PEM data")
+	}
+}
+
+func TestParseCertificateMarker(t *testing.T) {
+	serial := "1a2b3c"
+	fingerprint := strings.Repeat("0f", 32)
+
+	tests := []struct {
+		name        string
+		description string
+		ok          bool
+	}{
+		{"complete", "qiniu-ssl;ca=R11;serial=" + serial + ";sha256=" + fingerprint + ";issued=2026-01-02T03:04:05Z", true},
+		{"without issuer and time", "qiniu-ssl;serial=" + serial + ";sha256=" + fingerprint, true},
+		{"unknown fields", "qiniu-ssl;serial=" + serial + ";sha256=" + fingerprint + ";future=1;flag", true},
+		{"empty", "", false},
+		{"manual upload", "uploaded by hand", false},
+		{"tag only", "qiniu-ssl", false},
+		{"other tool", "qiniu-ssl-fork;serial=" + serial + ";sha256=" + fingerprint, false},
+		{"tag not first", "note;qiniu-ssl;serial=" + serial + ";sha256=" + fingerprint, false},
+		{"missing serial", "qiniu-ssl;sha256=" + fingerprint, false},
+		{"empty serial", "qiniu-ssl;serial=;sha256=" + fingerprint, false},
+		{"uppercase serial", "qiniu-ssl;serial=1A2B3C;sha256=" + fingerprint, false},
+		{"non-hex serial", "qiniu-ssl;serial=12:34;sha256=" + fingerprint, false},
+		{"missing fingerprint", "qiniu-ssl;serial=" + serial, false},
+		{"short fingerprint", "qiniu-ssl;serial=" + serial + ";sha256=" + fingerprint[2:], false},
+		{"non-hex fingerprint", "qiniu-ssl;serial=" + serial + ";sha256=" + strings.Repeat("zz", 32), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			marker, ok := qiniuapi.ParseCertificateMarker(tt.description)
+			if ok != tt.ok {
+				t.Fatalf("ParseCertificateMarker(%q) = %+v, %t, want %t", tt.description, marker, ok, tt.ok)
+			}
+			if ok && (marker.Serial != serial || marker.Fingerprint != fingerprint) {
+				t.Errorf("marker %+v, want serial %s and fingerprint %s", marker, serial, fingerprint)
+			}
+		})
+	}
+
+	marker, _ := qiniuapi.ParseCertificateMarker(tests[0].description)
+	if want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC); marker.Issuer != "R11" || !marker.IssuedAt.Equal(want) {
+		t.Errorf("marker %+v, want issuer R11 issued at %s", marker, want)
+	}
+}
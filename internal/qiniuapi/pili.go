@@ -0,0 +1,161 @@
+package qiniuapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// PiliDomain is a play or push domain of a Pili live-streaming hub
+type PiliDomain struct {
+	Domain     string `json:"domain"`
+	Type       string `json:"type"` // liveRtmp, liveHls, liveHdl or publishRtmp
+	CNAME      string `json:"cname"`
+	Disable    bool   `json:"disable"`
+	CertEnable bool   `json:"certEnable"`
+	CertID     string `json:"certId,omitempty"` // Bound certificate, empty if HTTPS is not enabled
+}
+
+// PiliHub is a Pili live-streaming hub
+type PiliHub struct {
+	Name string `json:"name"`
+}
+
+// ListPiliHubs retrieves the live-streaming hubs of the account.
+// Accounts without the live-streaming service have no hubs.
+func (q *QiniuClient) ListPiliHubs(ctx context.Context) ([]PiliHub, error) {
+	respBody, err := q.doRequest(ctx, http.MethodGet, q.piliURL+"/v2/hubs", nil)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list live-streaming hubs: %w", err)
+	}
+
+	var list struct {
+		Items []PiliHub `json:"items"`
+	}
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse live-streaming hub list: %w", err)
+	}
+
+	return list.Items, nil
+}
+
+// ListPiliDomains retrieves the play and push domains of a live-streaming hub
+func (q *QiniuClient) ListPiliDomains(ctx context.Context, hub string) ([]PiliDomain, error) {
+	url := fmt.Sprintf("%s/v2/hubs/%s/domains", q.piliURL, hub)
+	respBody, err := q.doRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains of hub %s: %w", hub, err)
+	}
+
+	var list struct {
+		Domains []PiliDomain `json:"domains"`
+	}
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse live-streaming domain list: %w", err)
+	}
+
+	return list.Domains, nil
+}
+
+// ListAllPiliDomains retrieves the domains of all live-streaming hubs of the account, by hub
+func (q *QiniuClient) ListAllPiliDomains(ctx context.Context) (map[string][]PiliDomain, error) {
+	hubs, err := q.ListPiliHubs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make(map[string][]PiliDomain, len(hubs))
+	for _, hub := range hubs {
+		if domains[hub.Name], err = q.ListPiliDomains(ctx, hub.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return domains, nil
+}
+
+// GetPiliDomain retrieves a domain of a live-streaming hub, including its bound certificate
+func (q *QiniuClient) GetPiliDomain(ctx context.Context, hub, domain string) (*PiliDomain, error) {
+	url := fmt.Sprintf("%s/v2/hubs/%s/domains/%s", q.piliURL, hub, domain)
+	respBody, err := q.doRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get live-streaming domain info: %w", err)
+	}
+
+	var info PiliDomain
+	if err := json.Unmarshal(respBody, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse live-streaming domain info: %w", err)
+	}
+
+	return &info, nil
+}
+
+// BindPiliDomainCertificate enables HTTPS on a domain of a live-streaming hub with the
+// certificate, or replaces its certificate
+func (q *QiniuClient) BindPiliDomainCertificate(ctx context.Context, hub, domain, certID string) error {
+	reqBody, err := json.Marshal(struct {
+		CertID string `json:"certId"`
+	}{
+		CertID: certID,
+	})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/v2/hubs/%s/domains/%s/cert", q.piliURL, hub, domain)
+	if _, err := q.doRequest(ctx, http.MethodPost, url, reqBody); err != nil {
+		return fmt.Errorf("failed to bind certificate to live-streaming domain: %w", err)
+	}
+
+	return nil
+}
+
+// CheckPiliCertificates checks the certificates bound to domains of live-streaming hubs,
+// given as a map of domain to hub, like CheckCertificates. Disabled domains are not
+// marked for renewal.
+func (q *QiniuClient) CheckPiliCertificates(ctx context.Context, domains map[string]string, thresholdDays, concurrency int) (map[string]*CertificateStatus, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultCheckConcurrency
+	}
+
+	names := make([]string, 0, len(domains))
+	statuses := make(map[string]*CertificateStatus, len(domains))
+	for domain := range domains {
+		names = append(names, domain)
+		statuses[domain] = &CertificateStatus{Domain: domain, NeedsRenewal: true}
+	}
+	sort.Strings(names)
+
+	certIDs := make(map[string]string, len(domains))
+	var mu sync.Mutex
+	forEach(ctx, names, concurrency, func(domain string) {
+		info, err := q.GetPiliDomain(ctx, domains[domain], domain)
+
+		mu.Lock()
+		defer mu.Unlock()
+		status := statuses[domain]
+		switch {
+		case err != nil:
+			status.Err = fmt.Errorf("failed to get domain info: %w", err)
+		case info.Disable:
+			status.NeedsRenewal = false
+			status.Err = fmt.Errorf("domain is disabled")
+		case !info.CertEnable || info.CertID == "":
+			status.Err = fmt.Errorf("domain does not have HTTPS enabled or certificate bound")
+		default:
+			certIDs[domain] = info.CertID
+		}
+	})
+
+	if err := q.checkExpiry(ctx, statuses, certIDs, thresholdDays, concurrency); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/qiniu/go-sdk/v7/auth"
@@ -16,6 +18,24 @@ import (
 const (
 	// QiniuAPIHost is the Qiniu API host
 	QiniuAPIHost = "https://api.qiniu.com"
+
+	// QiniuUCHost is the Qiniu bucket management API host, serving the Kodo bucket domains
+	QiniuUCHost = "https://uc.qiniuapi.com"
+
+	// QiniuPiliHost is the Qiniu live-streaming (Pili) API host
+	QiniuPiliHost = "https://pili.qiniuapi.com"
+
+	// ToolTag marks the description of certificates uploaded by this tool
+	ToolTag = "qiniu-ssl"
+
+	// defaultMaxRetries is the default number of retries of idempotent requests
+	defaultMaxRetries = 3
+
+	// defaultRetryBackoff is the default delay before the first retry
+	defaultRetryBackoff = time.Second
+
+	// maxRetryBackoff caps the exponential backoff between retries
+	maxRetryBackoff = 30 * time.Second
 )
 
 // QiniuClient represents a Qiniu API client
@@ -24,6 +44,52 @@ type QiniuClient struct {
 	secretKey string
 	mac       *auth.Credentials
 	client    *http.Client
+	baseURL   string
+	ucURL     string
+	piliURL   string
+
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Option configures a QiniuClient
+type Option func(*QiniuClient)
+
+// WithBaseURL sets the base URL of the Qiniu API, QiniuAPIHost by default
+func WithBaseURL(baseURL string) Option {
+	return func(q *QiniuClient) {
+		q.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithUCURL sets the base URL of the Qiniu bucket management API, QiniuUCHost by default
+func WithUCURL(ucURL string) Option {
+	return func(q *QiniuClient) {
+		q.ucURL = strings.TrimSuffix(ucURL, "/")
+	}
+}
+
+// WithPiliURL sets the base URL of the Qiniu live-streaming API, QiniuPiliHost by default
+func WithPiliURL(piliURL string) Option {
+	return func(q *QiniuClient) {
+		q.piliURL = strings.TrimSuffix(piliURL, "/")
+	}
+}
+
+// WithRetry sets how many times idempotent requests are retried
+// and the delay before the first retry, which doubles on each attempt
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(q *QiniuClient) {
+		q.maxRetries = maxRetries
+		q.retryBackoff = backoff
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for API requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(q *QiniuClient) {
+		q.client = client
+	}
 }
 
 // CertificateInfo represents certificate information
@@ -38,6 +104,19 @@ type CertificateInfo struct {
 	Description string   `json:"description"`
 	Pri         string   `json:"pri,omitempty"`
 	Ca          string   `json:"ca,omitempty"`
+
+	// Marker is parsed from Description, nil if not uploaded by this tool
+	Marker *CertificateMarker `json:"-"`
+}
+
+// UploadedByTool reports whether the certificate was uploaded by this tool
+func (c *CertificateInfo) UploadedByTool() bool {
+	return c.Marker != nil
+}
+
+// parseMarker fills Marker from Description
+func (c *CertificateInfo) parseMarker() {
+	c.Marker, _ = ParseCertificateMarker(c.Description)
 }
 
 type CertificateResponse struct {
@@ -63,15 +142,76 @@ type APIResponse struct {
 	Message string          `json:"message"`
 }
 
+// Domain operating states
+const (
+	OperatingStateProcessing = "processing"
+	OperatingStateSuccess    = "success"
+	OperatingStateFailed     = "failed"
+	OperatingStateFrozen     = "frozen"
+	OperatingStateOfflined   = "offlined"
+)
+
+// Domain types
+const (
+	DomainTypeNormal   = "normal"
+	DomainTypeWildcard = "wildcard"
+	DomainTypePan      = "pan"
+)
+
 // DomainInfo represents domain configuration information
 type DomainInfo struct {
-	Name     string      `json:"name"`
-	Type     string      `json:"type"`
-	Platform string      `json:"platform"`
-	GeoCover string      `json:"geoCover"`
-	Protocol string      `json:"protocol"`
-	HTTPS    *HTTPSInfo  `json:"https,omitempty"`
-	Source   interface{} `json:"source"`
+	Name               string      `json:"name"`
+	Type               string      `json:"type"`
+	ParentDomain       string      `json:"pareDomain,omitempty"` // Wildcard domain a pan domain belongs to
+	CNAME              string      `json:"cname"`
+	TestURLPath        string      `json:"testURLPath,omitempty"`
+	Platform           string      `json:"platform"`
+	GeoCover           string      `json:"geoCover"`
+	Protocol           string      `json:"protocol"`
+	QiniuPrivate       bool        `json:"qiniuPrivate,omitempty"`
+	HTTPS              *HTTPSInfo  `json:"https,omitempty"`
+	Source             *SourceInfo `json:"source,omitempty"`
+	Cache              *CacheInfo  `json:"cache,omitempty"`
+	Referer            *RefererACL `json:"referer,omitempty"`
+	IPACL              *IPACL      `json:"ipACL,omitempty"`
+	OperationType      string      `json:"operationType"`
+	OperatingState     string      `json:"operatingState"`
+	OperatingStateDesc string      `json:"operatingStateDesc"`
+	CreateAt           string      `json:"createAt,omitempty"`
+	ModifyAt           string      `json:"modifyAt,omitempty"`
+	CouldOperateBySelf bool        `json:"couldOperateBySelf,omitempty"`
+	UIDIsFreezed       bool        `json:"uidIsFreezed,omitempty"`
+}
+
+// IsProcessing reports whether an operation on the domain is still in progress
+func (d *DomainInfo) IsProcessing() bool {
+	return d.OperatingState == OperatingStateProcessing
+}
+
+// IsDisabled reports whether the domain is frozen or offline, or its account is frozen,
+// in which case its configuration cannot be changed
+func (d *DomainInfo) IsDisabled() bool {
+	return d.OperatingState == OperatingStateFrozen || d.OperatingState == OperatingStateOfflined || d.UIDIsFreezed
+}
+
+// IsWildcard reports whether the domain is a wildcard domain such as .example.com
+func (d *DomainInfo) IsWildcard() bool {
+	return d.Type == DomainTypeWildcard
+}
+
+// IsPan reports whether the domain is a pan domain, a subdomain of a wildcard domain
+func (d *DomainInfo) IsPan() bool {
+	return d.Type == DomainTypePan
+}
+
+// CreatedAt returns the creation time of the domain, zero if unknown
+func (d *DomainInfo) CreatedAt() time.Time {
+	return parseDomainTime(d.CreateAt)
+}
+
+// ModifiedAt returns the last modification time of the domain, zero if unknown
+func (d *DomainInfo) ModifiedAt() time.Time {
+	return parseDomainTime(d.ModifyAt)
 }
 
 // HTTPSInfo represents HTTPS configuration information
@@ -82,7 +222,7 @@ type HTTPSInfo struct {
 }
 
 // NewQiniuClient creates a new Qiniu API client
-func NewQiniuClient(accessKey, secretKey string) (*QiniuClient, error) {
+func NewQiniuClient(accessKey, secretKey string, opts ...Option) (*QiniuClient, error) {
 	if accessKey == "" || secretKey == "" {
 		return nil, fmt.Errorf("access key and secret key cannot be empty")
 	}
@@ -92,19 +232,80 @@ func NewQiniuClient(accessKey, secretKey string) (*QiniuClient, error) {
 		Timeout: 30 * time.Second,
 	}
 
-	return &QiniuClient{
+	q := &QiniuClient{
 		accessKey: accessKey,
 		secretKey: secretKey,
 		mac:       mac,
 		client:    client,
-	}, nil
+		baseURL:   QiniuAPIHost,
+		ucURL:     QiniuUCHost,
+		piliURL:   QiniuPiliHost,
+
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	if q.client == nil {
+		return nil, fmt.Errorf("HTTP client cannot be nil")
+	}
+
+	if q.maxRetries < 0 || q.retryBackoff <= 0 {
+		return nil, fmt.Errorf("invalid retry policy")
+	}
+
+	return q, nil
 }
 
-// doRequest performs an API request
+// doRequest performs an API request, retrying idempotent requests that fail
+// with a network error, a 5xx status or rate limiting
 func (q *QiniuClient) doRequest(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	idempotent := method != http.MethodPost
+	backoff := q.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		respBody, retryAfter, err := q.doRequestOnce(ctx, method, url, body)
+		if err == nil {
+			return respBody, nil
+		}
+
+		if !idempotent || attempt >= q.maxRetries || !IsRetryable(err) {
+			return nil, err
+		}
+
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		log.Printf("Request %s %s failed: %v, retrying in %s", method, url, err, wait)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// nextBackoff doubles the delay between retries, up to maxRetryBackoff
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// doRequestOnce performs a single API request.
+// It also returns the delay requested by the Retry-After header, if any.
+func (q *QiniuClient) doRequestOnce(ctx context.Context, method, url string, body []byte) ([]byte, time.Duration, error) {
 	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -112,39 +313,56 @@ func (q *QiniuClient) doRequest(ctx context.Context, method, url string, body []
 	// Sign the request
 	token, err := q.mac.SignRequestV2(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	req.Header.Set("Authorization", "Qiniu "+token)
 
 	// Send the request
 	resp, err := q.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	// Read response body
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			RequestID:  resp.Header.Get("X-Reqid"),
+		}
+
 		var apiResp APIResponse
-		if err := json.Unmarshal(respBody, &apiResp); err != nil {
-			return nil, fmt.Errorf("API error: %s", respBody)
+		if err := json.Unmarshal(respBody, &apiResp); err != nil || apiResp.Error == "" {
+			apiErr.Message = strings.TrimSpace(string(respBody))
+		} else {
+			apiErr.Code = apiResp.Code
+			apiErr.Message = apiResp.Error
+		}
+
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
 		}
-		log.Printf("API error: %s", respBody)
-		return nil, fmt.Errorf("API error: %s", apiResp.Error)
+
+		return nil, retryAfter, apiErr
 	}
 
-	return respBody, nil
+	return respBody, 0, nil
 }
 
 // UploadCertificate uploads a SSL certificate to Qiniu
-func (q *QiniuClient) UploadCertificate(name string, certPEM, keyPEM []byte) (string, error) {
-	ctx := context.Background()
+func (q *QiniuClient) UploadCertificate(ctx context.Context, name string, certPEM, keyPEM []byte) (string, error) {
+	// Mark the certificate as uploaded by this tool
+	marker, err := NewCertificateMarker(certPEM)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse certificate: %w", err)
+	}
 
 	// Configure certificate upload
 	certConfig := CertificateUploadRequest{
@@ -152,6 +370,7 @@ func (q *QiniuClient) UploadCertificate(name string, certPEM, keyPEM []byte) (st
 		Common_name: name,
 		Pri:         string(keyPEM),
 		Ca:          string(certPEM),
+		Description: marker.String(),
 	}
 
 	// Serialize request
@@ -162,10 +381,10 @@ func (q *QiniuClient) UploadCertificate(name string, certPEM, keyPEM []byte) (st
 
 	// Make API request
 	// 根据七牛云Python SDK的实现，证书上传API的正确路径为 /sslcert
-	url := QiniuAPIHost + "/sslcert"
+	url := q.baseURL + "/sslcert"
 	respBody, err := q.doRequest(ctx, http.MethodPost, url, reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload certificate: %v", err)
+		return "", fmt.Errorf("failed to upload certificate: %w", err)
 	}
 
 	// Parse response
@@ -174,27 +393,25 @@ func (q *QiniuClient) UploadCertificate(name string, certPEM, keyPEM []byte) (st
 	}
 
 	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
+		return "", fmt.Errorf("failed to parse response: %w", err)
 	}
 
 	return apiResp.CertID, nil
 }
 
 // GetDomainInfo retrieves information about a CDN domain
-func (q *QiniuClient) GetDomainInfo(domain string) (*DomainInfo, error) {
-	ctx := context.Background()
-
+func (q *QiniuClient) GetDomainInfo(ctx context.Context, domain string) (*DomainInfo, error) {
 	// Build request URL
-	url := fmt.Sprintf("%s/domain/%s", QiniuAPIHost, domain)
+	url := fmt.Sprintf("%s/domain/%s", q.baseURL, domain)
 	respBody, err := q.doRequest(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get domain info: %v", err)
+		return nil, fmt.Errorf("failed to get domain info: %w", err)
 	}
 
 	// Parse response
 	var info DomainInfo
 	if err := json.Unmarshal(respBody, &info); err != nil {
-		return nil, fmt.Errorf("failed to parse domain info: %v", err)
+		return nil, fmt.Errorf("failed to parse domain info: %w", err)
 	}
 
 	return &info, nil
@@ -203,9 +420,7 @@ func (q *QiniuClient) GetDomainInfo(domain string) (*DomainInfo, error) {
 // UpdateHTTPSConfig updates the HTTPS configuration for a domain with a certificate
 // This can be used to enable HTTPS support for a domain that doesn't already have it
 // or to update an existing HTTPS configuration with a new certificate
-func (q *QiniuClient) UpdateHTTPSConfig(domain, certID string, forceHTTPS, http2Enable bool) error {
-	ctx := context.Background()
-
+func (q *QiniuClient) UpdateHTTPSConfig(ctx context.Context, domain, certID string, forceHTTPS, http2Enable bool) error {
 	httpsConfig := struct {
 		CertID      string `json:"certid"`
 		ForceHttps  bool   `json:"forceHttps"`
@@ -222,10 +437,10 @@ func (q *QiniuClient) UpdateHTTPSConfig(domain, certID string, forceHTTPS, http2
 		return err
 	}
 
-	url := fmt.Sprintf("%s/domain/%s/httpsconf", QiniuAPIHost, domain)
+	url := fmt.Sprintf("%s/domain/%s/httpsconf", q.baseURL, domain)
 	_, err = q.doRequest(ctx, http.MethodPut, url, reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to update HTTPS configuration: %v", err)
+		return fmt.Errorf("failed to update HTTPS configuration: %w", err)
 	}
 
 	return nil
@@ -233,9 +448,7 @@ func (q *QiniuClient) UpdateHTTPSConfig(domain, certID string, forceHTTPS, http2
 
 // EnableHTTPS enables HTTPS for a domain
 // This should be called before UpdateHTTPSConfig if HTTPS is not already enabled
-func (q *QiniuClient) EnableHTTPS(domain, certID string, forceHTTPS, http2Enable bool) error {
-	ctx := context.Background()
-
+func (q *QiniuClient) EnableHTTPS(ctx context.Context, domain, certID string, forceHTTPS, http2Enable bool) error {
 	// API要求使用PUT方法并包含请求体
 	httpsConfig := struct {
 		CertID      string `json:"certid"`
@@ -254,23 +467,21 @@ func (q *QiniuClient) EnableHTTPS(domain, certID string, forceHTTPS, http2Enable
 	}
 
 	// 使用正确的API路径
-	url := fmt.Sprintf("%s/domain/%s/sslize", QiniuAPIHost, domain)
+	url := fmt.Sprintf("%s/domain/%s/sslize", q.baseURL, domain)
 	_, err = q.doRequest(ctx, http.MethodPut, url, reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to enable HTTPS for domain: %v", err)
+		return fmt.Errorf("failed to enable HTTPS for domain: %w", err)
 	}
 
 	return nil
 }
 
 // GetCertificateInfo retrieves information about a specific certificate by ID
-func (q *QiniuClient) GetCertificateInfo(certID string) (*CertificateInfo, error) {
-	ctx := context.Background()
-
-	url := fmt.Sprintf("%s/sslcert/%s", QiniuAPIHost, certID)
+func (q *QiniuClient) GetCertificateInfo(ctx context.Context, certID string) (*CertificateInfo, error) {
+	url := fmt.Sprintf("%s/sslcert/%s", q.baseURL, certID)
 	respBody, err := q.doRequest(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get certificate info: %v", err)
+		return nil, fmt.Errorf("failed to get certificate info: %w", err)
 	}
 
 	// Parse response
@@ -278,18 +489,19 @@ func (q *QiniuClient) GetCertificateInfo(certID string) (*CertificateInfo, error
 
 	// First, unmarshal the data to a map to process the date formats
 	if err := json.Unmarshal(respBody, &cert); err != nil {
-		return nil, fmt.Errorf("failed to parse certificate info: %v", err)
+		return nil, fmt.Errorf("failed to parse certificate info: %w", err)
 	}
+	cert.Cert.parseMarker()
 
 	return &cert.Cert, nil
 }
 
 // CheckCertificateFromQiniu checks if a domain's certificate in Qiniu is about to expire
-func (q *QiniuClient) CheckCertificateFromQiniu(domain string, thresholdDays int) (bool, *CertificateInfo, error) {
+func (q *QiniuClient) CheckCertificateFromQiniu(ctx context.Context, domain string, thresholdDays int) (bool, *CertificateInfo, error) {
 	// Get domain information
-	domainInfo, err := q.GetDomainInfo(domain)
+	domainInfo, err := q.GetDomainInfo(ctx, domain)
 	if err != nil {
-		return false, nil, fmt.Errorf("failed to get domain info: %v", err)
+		return false, nil, fmt.Errorf("failed to get domain info: %w", err)
 	}
 
 	// Check if HTTPS is enabled and a certificate is bound
@@ -299,9 +511,9 @@ func (q *QiniuClient) CheckCertificateFromQiniu(domain string, thresholdDays int
 
 	// Get certificate information
 	certID := domainInfo.HTTPS.CertID
-	certInfo, err := q.GetCertificateInfo(certID)
+	certInfo, err := q.GetCertificateInfo(ctx, certID)
 	if err != nil {
-		return false, nil, fmt.Errorf("failed to get certificate info: %v", err)
+		return false, nil, fmt.Errorf("failed to get certificate info: %w", err)
 	}
 
 	// Check if certificate is about to expire
@@ -310,3 +522,40 @@ func (q *QiniuClient) CheckCertificateFromQiniu(domain string, thresholdDays int
 
 	return needsRenewal, certInfo, nil
 }
+
+// WaitForDomainOperation polls the domain until its current operation is no longer processing,
+// checking every interval for at most timeout. It returns the final domain information,
+// and a *DomainOperationError if the operation failed.
+func (q *QiniuClient) WaitForDomainOperation(ctx context.Context, domain string, timeout, interval time.Duration) (*DomainInfo, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		info, err := q.GetDomainInfo(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsProcessing() {
+			if info.OperatingState == OperatingStateFailed {
+				return info, &DomainOperationError{
+					Domain:        domain,
+					OperationType: info.OperationType,
+					State:         info.OperatingState,
+					Description:   info.OperatingStateDesc,
+				}
+			}
+			return info, nil
+		}
+
+		if time.Now().After(deadline) {
+			return info, fmt.Errorf("timed out after %s waiting for operation %s on domain %s to finish",
+				timeout, info.OperationType, domain)
+		}
+
+		select {
+		case <-ctx.Done():
+			return info, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package qiniuapi_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi/qiniufake"
+)
+
+// newTestClient starts a fake Qiniu server and returns a client using it for all APIs
+func newTestClient(t *testing.T, opts ...qiniuapi.Option) (*qiniufake.Server, *qiniuapi.QiniuClient) {
+	t.Helper()
+
+	server := qiniufake.NewServer()
+	t.Cleanup(server.Close)
+
+	opts = append([]qiniuapi.Option{
+		qiniuapi.WithBaseURL(server.URL),
+		qiniuapi.WithUCURL(server.URL),
+		qiniuapi.WithPiliURL(server.URL),
+	}, opts...)
+	qiniu, err := qiniuapi.NewQiniuClient("ak", "sk", opts...)
+	if err != nil {
+		t.Fatalf("NewQiniuClient: %v", err)
+	}
+
+	return server, qiniu
+}
+
+// newTestCertificate returns a self-signed certificate for the names, currently valid
+func newTestCertificate(t *testing.T, names ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		t.Fatalf("serial: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 0, 90),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// uploadTestCertificate uploads a new certificate for the names and returns its ID
+func uploadTestCertificate(t *testing.T, qiniu *qiniuapi.QiniuClient, names ...string) string {
+	t.Helper()
+
+	certPEM, keyPEM := newTestCertificate(t, names...)
+	certID, err := qiniu.UploadCertificate(context.Background(), names[0], certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("UploadCertificate: %v", err)
+	}
+	return certID
+}
+
+// apiError returns the *qiniuapi.APIError wrapped by err, failing the test if there is none
+func apiError(t *testing.T, err error) *qiniuapi.APIError {
+	t.Helper()
+
+	var apiErr *qiniuapi.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want a *qiniuapi.APIError", err)
+	}
+	return apiErr
+}
+
+func TestUploadCertificate(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	certPEM, keyPEM := newTestCertificate(t, "cdn.example.com", "www.example.com")
+
+	certID, err := qiniu.UploadCertificate(context.Background(), "cdn.example.com", certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("UploadCertificate: %v", err)
+	}
+
+	uploaded, ok := server.Certificate(certID)
+	if !ok {
+		t.Fatalf("certificate %s not uploaded", certID)
+	}
+	if uploaded.Name != "cdn.example.com" || len(uploaded.DNSNames) != 2 {
+		t.Errorf("uploaded certificate %s for %v, want cdn.example.com for 2 names", uploaded.Name, uploaded.DNSNames)
+	}
+
+	cert, err := qiniu.GetCertificateInfo(context.Background(), certID)
+	if err != nil {
+		t.Fatalf("GetCertificateInfo: %v", err)
+	}
+	marker, err := qiniuapi.NewCertificateMarker(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.UploadedByTool() || cert.Marker.Fingerprint != marker.Fingerprint {
+		t.Errorf("certificate marker %+v, want fingerprint %s", cert.Marker, marker.Fingerprint)
+	}
+}
+
+func TestUploadCertificateErrors(t *testing.T) {
+	_, qiniu := newTestClient(t)
+	certPEM, _ := newTestCertificate(t, "cdn.example.com")
+
+	tests := []struct {
+		name   string
+		keyPEM []byte
+		code   int
+	}{
+		{"missing key", nil, qiniuapi.CodeInvalidArgs},
+		{"invalid key", []byte("not a key"), qiniuapi.CodeInvalidCert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := qiniu.UploadCertificate(context.Background(), "cdn.example.com", certPEM, tt.keyPEM)
+			apiErr := apiError(t, err)
+			if apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != tt.code || apiErr.Message == "" {
+				t.Errorf("got %+v, want status 400 with code %d and a message", apiErr, tt.code)
+			}
+			if apiErr.RequestID == "" {
+				t.Errorf("request ID of %v not parsed", apiErr)
+			}
+		})
+	}
+
+	if _, err := qiniu.GetCertificateInfo(context.Background(), "missing"); !qiniuapi.IsNotFound(err) {
+		t.Errorf("GetCertificateInfo of a missing certificate: got %v, want not found", err)
+	}
+}
+
+func TestGetDomainInfo(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com", Platform: "web"})
+
+	info, err := qiniu.GetDomainInfo(context.Background(), "cdn.example.com")
+	if err != nil {
+		t.Fatalf("GetDomainInfo: %v", err)
+	}
+	if info.Name != "cdn.example.com" || info.Protocol != "http" || info.CNAME == "" || info.Platform != "web" {
+		t.Errorf("got %+v, want the http domain cdn.example.com with a CNAME", info)
+	}
+
+	_, err = qiniu.GetDomainInfo(context.Background(), "missing.example.com")
+	apiErr := apiError(t, err)
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != qiniuapi.CodeDomainNotFound || !qiniuapi.IsNotFound(err) {
+		t.Errorf("GetDomainInfo of a missing domain: got %+v, want not found", apiErr)
+	}
+}
+
+func TestEnableHTTPS(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com"})
+	certID := uploadTestCertificate(t, qiniu, "cdn.example.com")
+	otherCertID := uploadTestCertificate(t, qiniu, "other.example.com")
+
+	tests := []struct {
+		name   string
+		domain string
+		certID string
+		code   int
+	}{
+		{"missing domain", "missing.example.com", certID, qiniuapi.CodeDomainNotFound},
+		{"missing certificate", "cdn.example.com", "missing", qiniuapi.CodeCertNotFound},
+		{"mismatching certificate", "cdn.example.com", otherCertID, qiniuapi.CodeCertDomainMatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := qiniu.EnableHTTPS(context.Background(), tt.domain, tt.certID, true, true)
+			if apiErr := apiError(t, err); apiErr.Code != tt.code {
+				t.Errorf("got %v, want code %d", err, tt.code)
+			}
+		})
+	}
+
+	if err := qiniu.EnableHTTPS(context.Background(), "cdn.example.com", certID, true, true); err != nil {
+		t.Fatalf("EnableHTTPS: %v", err)
+	}
+	info, _ := server.Domain("cdn.example.com")
+	if info.Protocol != "https" || info.HTTPS == nil || info.HTTPS.CertID != certID || !info.HTTPS.ForceHttps || !info.HTTPS.Http2Enable {
+		t.Errorf("domain %s with HTTPS %+v, want https with certificate %s, force HTTPS and HTTP/2", info.Protocol, info.HTTPS, certID)
+	}
+
+	err := qiniu.EnableHTTPS(context.Background(), "cdn.example.com", certID, true, true)
+	if apiErr := apiError(t, err); apiErr.Code != qiniuapi.CodeDomainAlreadySSL {
+		t.Errorf("EnableHTTPS of an https domain: got %v, want code %d", err, qiniuapi.CodeDomainAlreadySSL)
+	}
+}
+
+func TestUpdateHTTPSConfig(t *testing.T) {
+	server, qiniu := newTestClient(t)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com"})
+	certID := uploadTestCertificate(t, qiniu, "*.example.com")
+
+	err := qiniu.UpdateHTTPSConfig(context.Background(), "cdn.example.com", certID, false, false)
+	if apiErr := apiError(t, err); apiErr.Code != qiniuapi.CodeDomainNotHTTPS {
+		t.Errorf("UpdateHTTPSConfig of an http domain: got %v, want code %d", err, qiniuapi.CodeDomainNotHTTPS)
+	}
+
+	server.SetProcessingDuration(time.Hour)
+	if err := qiniu.EnableHTTPS(context.Background(), "cdn.example.com", certID, false, false); err != nil {
+		t.Fatalf("EnableHTTPS: %v", err)
+	}
+
+	newCertID := uploadTestCertificate(t, qiniu, "cdn.example.com")
+	err = qiniu.UpdateHTTPSConfig(context.Background(), "cdn.example.com", newCertID, true, false)
+	if !qiniuapi.IsDomainProcessing(err) {
+		t.Errorf("UpdateHTTPSConfig of a processing domain: got %v, want domain processing", err)
+	}
+
+	server.SetProcessingDuration(0)
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com", Protocol: "https", HTTPS: &qiniuapi.HTTPSInfo{CertID: certID}})
+	if err := qiniu.UpdateHTTPSConfig(context.Background(), "cdn.example.com", newCertID, true, false); err != nil {
+		t.Fatalf("UpdateHTTPSConfig: %v", err)
+	}
+
+	info, _ := server.Domain("cdn.example.com")
+	if info.HTTPS.CertID != newCertID || !info.HTTPS.ForceHttps || info.OperationType != "modify_https_conf" {
+		t.Errorf("HTTPS %+v after %s, want certificate %s with force HTTPS", info.HTTPS, info.OperationType, newCertID)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	server, qiniu := newTestClient(t, qiniuapi.WithRetry(0, time.Millisecond))
+
+	server.FailNext(1, http.StatusTooManyRequests, http.StatusTooManyRequests, "too many requests")
+	_, err := qiniu.GetDomainInfo(context.Background(), "cdn.example.com")
+	if !qiniuapi.IsRateLimited(err) || !qiniuapi.IsRetryable(err) {
+		t.Errorf("got %v, want a retryable rate limiting error", err)
+	}
+
+	server.FailNext(1, http.StatusBadRequest, qiniuapi.CodeDomainProcessing, "domain is processing")
+	_, err = qiniu.GetDomainInfo(context.Background(), "cdn.example.com")
+	if !qiniuapi.IsDomainProcessing(err) || qiniuapi.IsRetryable(err) || qiniuapi.IsNotFound(err) {
+		t.Errorf("got %v, want a domain processing error only", err)
+	}
+	if apiErr := apiError(t, err); apiErr.Message != "domain is processing" {
+		t.Errorf("message %q, want the error of the response body", apiErr.Message)
+	}
+}
+
+func TestIsCertNotFound(t *testing.T) {
+	_, qiniu := newTestClient(t)
+
+	_, err := qiniu.GetCertificateInfo(context.Background(), "missing")
+	if !qiniuapi.IsCertNotFound(err) {
+		t.Errorf("missing certificate: got %v, want certificate not found", err)
+	}
+
+	_, err = qiniu.GetDomainInfo(context.Background(), "missing.example.com")
+	if qiniuapi.IsCertNotFound(err) || !qiniuapi.IsNotFound(err) {
+		t.Errorf("missing domain: got %v, want domain not found only", err)
+	}
+}
+
+func TestGetCertificateBindingsWithoutKodoAndPili(t *testing.T) {
+	// Kodo and live-streaming are served separately, so that only their requests fail
+	kodo := qiniufake.NewServer()
+	t.Cleanup(kodo.Close)
+	pili := qiniufake.NewServer()
+	t.Cleanup(pili.Close)
+
+	server, qiniu := newTestClient(t, qiniuapi.WithUCURL(kodo.URL), qiniuapi.WithPiliURL(pili.URL), qiniuapi.WithRetry(0, time.Millisecond))
+	certID := uploadTestCertificate(t, qiniu, "cdn.example.com")
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com", Protocol: "https", HTTPS: &qiniuapi.HTTPSInfo{CertID: certID}})
+
+	// A key restricted to the CDN
+	kodo.FailNext(1, http.StatusUnauthorized, http.StatusUnauthorized, "bad token")
+	pili.FailNext(1, http.StatusForbidden, http.StatusForbidden, "access denied")
+	bindings, err := qiniu.GetCertificateBindings(context.Background())
+	if err != nil {
+		t.Fatalf("GetCertificateBindings: %v", err)
+	}
+	if got := bindings[certID]; len(got) != 1 || got[0] != "cdn.example.com" {
+		t.Errorf("bindings of %s: %v, want the CDN domain", certID, got)
+	}
+
+	// Other errors are not ignored
+	kodo.FailNext(1, http.StatusInternalServerError, http.StatusInternalServerError, "internal error")
+	if _, err := qiniu.GetCertificateBindings(context.Background()); err == nil {
+		t.Error("GetCertificateBindings succeeded despite a server error")
+	}
+}
+
+// flakyTransport counts the requests sent through it and fails the first ones
+// with a network error
+type flakyTransport struct {
+	mu       sync.Mutex
+	requests int
+	failures int
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.requests++
+	fail := f.requests <= f.failures
+	f.mu.Unlock()
+
+	if fail {
+		return nil, errors.New("connection reset by peer")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name           string
+		post           bool // Upload a certificate instead of getting a domain
+		networkErrors  int
+		serverFailures int
+		status         int
+		code           int
+		wantErr        bool
+		wantRequests   int
+	}{
+		{name: "server errors", serverFailures: 2, status: http.StatusServiceUnavailable, code: http.StatusServiceUnavailable, wantRequests: 3},
+		{name: "rate limiting", serverFailures: 2, status: http.StatusTooManyRequests, code: http.StatusTooManyRequests, wantRequests: 3},
+		{name: "network errors", networkErrors: 2, wantRequests: 3},
+		{name: "retries exhausted", serverFailures: 5, status: http.StatusInternalServerError, code: http.StatusInternalServerError, wantErr: true, wantRequests: 4},
+		{name: "client error", serverFailures: 1, status: http.StatusBadRequest, code: qiniuapi.CodeInvalidArgs, wantErr: true, wantRequests: 1},
+		{name: "POST server error", post: true, serverFailures: 1, status: http.StatusServiceUnavailable, code: http.StatusServiceUnavailable, wantErr: true, wantRequests: 1},
+		{name: "POST network error", post: true, networkErrors: 1, wantErr: true, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &flakyTransport{failures: tt.networkErrors}
+			server, qiniu := newTestClient(t,
+				qiniuapi.WithRetry(3, time.Millisecond),
+				qiniuapi.WithHTTPClient(&http.Client{Transport: transport}))
+			server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com"})
+			if tt.serverFailures > 0 {
+				server.FailNext(tt.serverFailures, tt.status, tt.code, http.StatusText(tt.status))
+			}
+
+			var err error
+			if tt.post {
+				certPEM, keyPEM := newTestCertificate(t, "cdn.example.com")
+				_, err = qiniu.UploadCertificate(context.Background(), "cdn.example.com", certPEM, keyPEM)
+			} else {
+				_, err = qiniu.GetDomainInfo(context.Background(), "cdn.example.com")
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if transport.requests != tt.wantRequests {
+				t.Errorf("sent %d requests, want %d", transport.requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenCanceled(t *testing.T) {
+	server, qiniu := newTestClient(t, qiniuapi.WithRetry(3, time.Hour))
+	server.FailNext(1, http.StatusServiceUnavailable, http.StatusServiceUnavailable, "service unavailable")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := qiniu.GetDomainInfo(ctx, "cdn.example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context error instead of waiting for the backoff", err)
+	}
+}
+
+func TestCheckListedCertificates(t *testing.T) {
+	transport := &flakyTransport{}
+	server, qiniu := newTestClient(t, qiniuapi.WithHTTPClient(&http.Client{Transport: transport}))
+	certID := uploadTestCertificate(t, qiniu, "cdn.example.com")
+	server.AddDomain(qiniuapi.DomainInfo{Name: "cdn.example.com", Protocol: "https", HTTPS: &qiniuapi.HTTPSInfo{CertID: certID}})
+	server.AddDomain(qiniuapi.DomainInfo{Name: "www.example.com"})
+
+	listed, err := qiniu.ListAllDomains(context.Background())
+	if err != nil {
+		t.Fatalf("ListAllDomains: %v", err)
+	}
+
+	transport.requests = 0
+	statuses, err := qiniu.CheckListedCertificates(context.Background(), listed, []string{"cdn.example.com", "www.example.com"}, 30, 1)
+	if err != nil {
+		t.Fatalf("CheckListedCertificates: %v", err)
+	}
+
+	// Only the HTTPS configuration and the certificate are retrieved, the domains are not listed again
+	if transport.requests != 2 {
+		t.Errorf("sent %d requests, want 2", transport.requests)
+	}
+	if status := statuses["cdn.example.com"]; status.Err != nil || status.Certificate == nil || status.NeedsRenewal {
+		t.Errorf("status of cdn.example.com %+v, want a valid certificate", status)
+	}
+	if status := statuses["www.example.com"]; status.Err == nil || !status.NeedsRenewal {
+		t.Errorf("status of www.example.com %+v, want an HTTP domain needing a certificate", status)
+	}
+}
@@ -0,0 +1,782 @@
+package qiniufake
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+)
+
+const (
+	// CodeBadToken is returned when a request is not signed
+	CodeBadToken = 401
+
+	// CodeNoSuchBucket is returned when a bucket does not exist
+	CodeNoSuchBucket = 612
+)
+
+// Server is a fake Qiniu API server implementing the certificate, CDN domain,
+// Kodo bucket domain and live-streaming domain endpoints used by qiniuapi, for offline
+// testing. It serves the bucket management and live-streaming APIs on the same URL.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	domains  map[string]*qiniuapi.DomainInfo
+	certs    map[string]*qiniuapi.CertificateInfo
+	buckets  map[string][]*qiniuapi.BucketDomain
+	hubs     map[string][]*qiniuapi.PiliDomain
+	nextID   int
+	failures []failure
+
+	// Domain operations are processed asynchronously
+	processingDuration time.Duration
+	processingUntil    map[string]time.Time
+	operationFailures  []string
+}
+
+// failure is an error response injected with FailNext
+type failure struct {
+	status  int
+	code    int
+	message string
+}
+
+// NewServer starts a new fake Qiniu API server.
+// The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		domains: make(map[string]*qiniuapi.DomainInfo),
+		certs:   make(map[string]*qiniuapi.CertificateInfo),
+		buckets: make(map[string][]*qiniuapi.BucketDomain),
+		hubs:    make(map[string][]*qiniuapi.PiliDomain),
+
+		processingUntil: make(map[string]time.Time),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// AddDomain adds a CDN domain to the fake account
+func (s *Server) AddDomain(info qiniuapi.DomainInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info.Protocol == "" {
+		info.Protocol = "http"
+	}
+	if info.OperatingState == "" {
+		info.OperatingState = qiniuapi.OperatingStateSuccess
+	}
+	if info.Type == "" {
+		info.Type = qiniuapi.DomainTypeNormal
+	}
+	if info.CNAME == "" {
+		info.CNAME = info.Name + ".qiniudns.com"
+	}
+	if info.CreateAt == "" {
+		info.CreateAt = time.Now().Format(time.RFC3339)
+		info.ModifyAt = info.CreateAt
+	}
+	s.domains[info.Name] = &info
+}
+
+// AddBucketDomain binds a custom domain to a Kodo bucket of the fake account,
+// creating the bucket if needed
+func (s *Server) AddBucketDomain(bucket, domain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	s.buckets[bucket] = append(s.buckets[bucket], &qiniuapi.BucketDomain{
+		Domain:     domain,
+		Bucket:     bucket,
+		CreateTime: now,
+		UpdateTime: now,
+	})
+}
+
+// BucketDomain returns a custom domain of a Kodo bucket of the fake account
+func (s *Server) BucketDomain(domain string) (qiniuapi.BucketDomain, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d := s.findBucketDomain(domain); d != nil {
+		return *d, true
+	}
+	return qiniuapi.BucketDomain{}, false
+}
+
+// AddPiliDomain adds a domain to a live-streaming hub of the fake account,
+// creating the hub if needed
+func (s *Server) AddPiliDomain(hub string, domain qiniuapi.PiliDomain) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if domain.Type == "" {
+		domain.Type = "liveHls"
+	}
+	if domain.CNAME == "" {
+		domain.CNAME = domain.Domain + ".qiniudns.com"
+	}
+	s.hubs[hub] = append(s.hubs[hub], &domain)
+}
+
+// PiliDomain returns a domain of a live-streaming hub of the fake account
+func (s *Server) PiliDomain(hub, domain string) (qiniuapi.PiliDomain, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d := s.findPiliDomain(hub, domain); d != nil {
+		return *d, true
+	}
+	return qiniuapi.PiliDomain{}, false
+}
+
+// SetProcessingDuration sets how long domain operations stay in the processing state
+func (s *Server) SetProcessingDuration(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processingDuration = d
+}
+
+// FailNextOperation makes the next domain operation end in the failed state with desc
+func (s *Server) FailNextOperation(desc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.operationFailures = append(s.operationFailures, desc)
+}
+
+// Domain returns a CDN domain of the fake account
+func (s *Server) Domain(name string) (qiniuapi.DomainInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.domains[name]
+	if !ok {
+		return qiniuapi.DomainInfo{}, false
+	}
+	s.settle(info)
+	return *info, true
+}
+
+// Certificate returns a certificate of the fake account
+func (s *Server) Certificate(id string) (qiniuapi.CertificateInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cert, ok := s.certs[id]
+	if !ok {
+		return qiniuapi.CertificateInfo{}, false
+	}
+	return *cert, true
+}
+
+// FailNext makes the next n requests fail with the given status, code and message
+func (s *Server) FailNext(n, status, code int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status: status, code: code, message: message})
+	}
+}
+
+// handle routes a request to the matching endpoint
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Qiniu ") {
+		writeError(w, http.StatusUnauthorized, CodeBadToken, "bad token")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.failures) > 0 {
+		f := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, f.status, f.code, f.message)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case parts[0] == "sslcert" && len(parts) == 1 && r.Method == http.MethodPost:
+		s.uploadCertificate(w, r)
+	case parts[0] == "sslcert" && len(parts) == 1 && r.Method == http.MethodGet:
+		s.listCertificates(w, r)
+	case parts[0] == "sslcert" && len(parts) == 2 && r.Method == http.MethodGet:
+		s.getCertificate(w, parts[1])
+	case parts[0] == "sslcert" && len(parts) == 2 && r.Method == http.MethodDelete:
+		s.deleteCertificate(w, parts[1])
+	case parts[0] == "domain" && len(parts) == 1 && r.Method == http.MethodGet:
+		s.listDomains(w, r)
+	case parts[0] == "domain" && len(parts) == 2 && r.Method == http.MethodGet:
+		s.getDomain(w, parts[1])
+	case parts[0] == "domain" && len(parts) == 2 && r.Method == http.MethodPost:
+		s.createDomain(w, r, parts[1])
+	case parts[0] == "domain" && len(parts) == 3 && parts[2] == "httpsconf" && r.Method == http.MethodPut:
+		s.updateHTTPSConfig(w, r, parts[1])
+	case parts[0] == "domain" && len(parts) == 3 && parts[2] == "sslize" && r.Method == http.MethodPut:
+		s.sslize(w, r, parts[1])
+	case parts[0] == "buckets" && len(parts) == 1 && r.Method == http.MethodGet:
+		s.listBuckets(w)
+	case parts[0] == "v3" && len(parts) == 2 && parts[1] == "domains" && r.Method == http.MethodGet:
+		s.listBucketDomains(w, r)
+	case parts[0] == "cert" && len(parts) == 2 && parts[1] == "bind" && r.Method == http.MethodPost:
+		s.bindBucketDomainCertificate(w, r)
+	case parts[0] == "v2" && len(parts) == 2 && parts[1] == "hubs" && r.Method == http.MethodGet:
+		s.listPiliHubs(w)
+	case parts[0] == "v2" && len(parts) == 4 && parts[1] == "hubs" && parts[3] == "domains" && r.Method == http.MethodGet:
+		s.listPiliDomains(w, parts[2])
+	case parts[0] == "v2" && len(parts) == 5 && parts[1] == "hubs" && parts[3] == "domains" && r.Method == http.MethodGet:
+		s.getPiliDomain(w, parts[2], parts[4])
+	case parts[0] == "v2" && len(parts) == 6 && parts[1] == "hubs" && parts[3] == "domains" && parts[5] == "cert" && r.Method == http.MethodPost:
+		s.bindPiliDomainCertificate(w, r, parts[2], parts[4])
+	default:
+		writeError(w, http.StatusNotFound, http.StatusNotFound, "404 page not found")
+	}
+}
+
+// uploadCertificate handles POST /sslcert
+func (s *Server) uploadCertificate(w http.ResponseWriter, r *http.Request) {
+	var req qiniuapi.CertificateUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "invalid args")
+		return
+	}
+
+	if req.Name == "" || req.Pri == "" || req.Ca == "" {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "name, pri and ca are required")
+		return
+	}
+
+	leaf, err := parseLeaf(req.Ca)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidCert, "invalid certificate: "+err.Error())
+		return
+	}
+
+	if block, _ := pem.Decode([]byte(req.Pri)); block == nil {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidCert, "invalid private key")
+		return
+	}
+
+	s.nextID++
+	id := fmt.Sprintf("fake%020d", s.nextID)
+	s.certs[id] = &qiniuapi.CertificateInfo{
+		ID:          id,
+		Name:        req.Name,
+		Common:      req.Common_name,
+		DNSNames:    leaf.DNSNames,
+		NotBefore:   leaf.NotBefore.Unix(),
+		NotAfter:    leaf.NotAfter.Unix(),
+		CreateTime:  time.Now().Unix(),
+		Description: req.Description,
+		Pri:         req.Pri,
+		Ca:          req.Ca,
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":   http.StatusOK,
+		"error":  "",
+		"certID": id,
+	})
+}
+
+// getCertificate handles GET /sslcert/{id}
+func (s *Server) getCertificate(w http.ResponseWriter, id string) {
+	cert, ok := s.certs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, qiniuapi.CodeCertNotFound, "cert not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, qiniuapi.CertificateResponse{
+		Code: http.StatusOK,
+		Cert: *cert,
+	})
+}
+
+// listCertificates handles GET /sslcert
+func (s *Server) listCertificates(w http.ResponseWriter, r *http.Request) {
+	ids := make([]string, 0, len(s.certs))
+	for id := range s.certs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	start, end, marker, ok := page(w, r, len(ids))
+	if !ok {
+		return
+	}
+
+	certs := make([]qiniuapi.CertificateInfo, 0, end-start)
+	for _, id := range ids[start:end] {
+		cert := *s.certs[id]
+		cert.Pri = ""
+		certs = append(certs, cert)
+	}
+
+	writeJSON(w, http.StatusOK, qiniuapi.CertificateListResponse{Marker: marker, Certs: certs})
+}
+
+// deleteCertificate handles DELETE /sslcert/{id}
+func (s *Server) deleteCertificate(w http.ResponseWriter, id string) {
+	if _, ok := s.certs[id]; !ok {
+		writeError(w, http.StatusNotFound, qiniuapi.CodeCertNotFound, "cert not found")
+		return
+	}
+
+	for _, info := range s.domains {
+		if info.HTTPS != nil && info.HTTPS.CertID == id {
+			writeError(w, http.StatusBadRequest, qiniuapi.CodeCertInUse, "cert is in use by domain "+info.Name)
+			return
+		}
+	}
+	for _, domains := range s.buckets {
+		for _, domain := range domains {
+			if domain.CertID == id {
+				writeError(w, http.StatusBadRequest, qiniuapi.CodeCertInUse, "cert is in use by domain "+domain.Domain)
+				return
+			}
+		}
+	}
+	for _, domains := range s.hubs {
+		for _, domain := range domains {
+			if domain.CertID == id {
+				writeError(w, http.StatusBadRequest, qiniuapi.CodeCertInUse, "cert is in use by domain "+domain.Domain)
+				return
+			}
+		}
+	}
+
+	delete(s.certs, id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"code": http.StatusOK, "error": ""})
+}
+
+// listDomains handles GET /domain
+func (s *Server) listDomains(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(s.domains))
+	for name := range s.domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	start, end, marker, ok := page(w, r, len(names))
+	if !ok {
+		return
+	}
+
+	domains := make([]qiniuapi.DomainInfo, 0, end-start)
+	for _, name := range names[start:end] {
+		info := s.domains[name]
+		s.settle(info)
+
+		// The domain list does not include the HTTPS configuration
+		listed := *info
+		listed.HTTPS = nil
+		domains = append(domains, listed)
+	}
+
+	writeJSON(w, http.StatusOK, qiniuapi.DomainListResponse{Marker: marker, Domains: domains})
+}
+
+// createDomain handles POST /domain/{name}
+func (s *Server) createDomain(w http.ResponseWriter, r *http.Request, name string) {
+	if _, ok := s.domains[name]; ok {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "domain already exists")
+		return
+	}
+
+	var req qiniuapi.CreateDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "invalid request body")
+		return
+	}
+
+	if req.Source == nil || req.Source.SourceType == "" {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "source is required")
+		return
+	}
+
+	info := &qiniuapi.DomainInfo{
+		Name:     name,
+		Type:     req.Type,
+		Platform: req.Platform,
+		GeoCover: req.GeoCover,
+		Protocol: req.Protocol,
+		Source:   req.Source,
+		Cache:    req.Cache,
+		CNAME:    name + ".qiniudns.com",
+		CreateAt: time.Now().Format(time.RFC3339),
+	}
+	if req.Protocol == "https" {
+		if req.HTTPS == nil {
+			writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "https config is required")
+			return
+		}
+		cert, ok := s.certs[req.HTTPS.CertID]
+		if !ok {
+			writeError(w, http.StatusBadRequest, qiniuapi.CodeCertNotFound, "cert not found")
+			return
+		}
+		if !certCoversDomain(cert, name) {
+			writeError(w, http.StatusBadRequest, qiniuapi.CodeCertDomainMatch, "cert does not match domain")
+			return
+		}
+		info.HTTPS = req.HTTPS
+	}
+
+	s.domains[name] = info
+	s.startOperation(info, "create_domain")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"code": http.StatusOK, "error": ""})
+}
+
+// getDomain handles GET /domain/{name}
+func (s *Server) getDomain(w http.ResponseWriter, name string) {
+	info, ok := s.domains[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, qiniuapi.CodeDomainNotFound, "no such domain")
+		return
+	}
+
+	s.settle(info)
+	writeJSON(w, http.StatusOK, info)
+}
+
+// updateHTTPSConfig handles PUT /domain/{name}/httpsconf
+func (s *Server) updateHTTPSConfig(w http.ResponseWriter, r *http.Request, name string) {
+	info, ok := s.domains[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, qiniuapi.CodeDomainNotFound, "no such domain")
+		return
+	}
+
+	if !s.checkNotProcessing(w, info) {
+		return
+	}
+
+	if info.Protocol != "https" {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeDomainNotHTTPS, "domain protocol is not https, please sslize it first")
+		return
+	}
+
+	https, ok := s.decodeHTTPSConfig(w, r, name)
+	if !ok {
+		return
+	}
+
+	info.HTTPS = https
+	s.startOperation(info, "modify_https_conf")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"code": http.StatusOK, "error": ""})
+}
+
+// sslize handles PUT /domain/{name}/sslize
+func (s *Server) sslize(w http.ResponseWriter, r *http.Request, name string) {
+	info, ok := s.domains[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, qiniuapi.CodeDomainNotFound, "no such domain")
+		return
+	}
+
+	if !s.checkNotProcessing(w, info) {
+		return
+	}
+
+	if info.Protocol == "https" {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeDomainAlreadySSL, "domain is already https")
+		return
+	}
+
+	https, ok := s.decodeHTTPSConfig(w, r, name)
+	if !ok {
+		return
+	}
+
+	info.Protocol = "https"
+	info.HTTPS = https
+	s.startOperation(info, "sslize")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"code": http.StatusOK, "error": ""})
+}
+
+// listBuckets handles GET /buckets
+func (s *Server) listBuckets(w http.ResponseWriter) {
+	buckets := make([]string, 0, len(s.buckets))
+	for bucket := range s.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	writeJSON(w, http.StatusOK, buckets)
+}
+
+// listBucketDomains handles GET /v3/domains?tbl={bucket}
+func (s *Server) listBucketDomains(w http.ResponseWriter, r *http.Request) {
+	bucket := r.URL.Query().Get("tbl")
+	domains, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNoSuchBucket, "no such bucket")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, domains)
+}
+
+// bindBucketDomainCertificate handles POST /cert/bind
+func (s *Server) bindBucketDomainCertificate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Domain string `json:"domain"`
+		CertID string `json:"certid"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "invalid request body")
+		return
+	}
+
+	domain := s.findBucketDomain(req.Domain)
+	if domain == nil {
+		writeError(w, http.StatusNotFound, qiniuapi.CodeDomainNotFound, "domain not found")
+		return
+	}
+
+	cert, ok := s.certs[req.CertID]
+	if !ok {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeCertNotFound, "cert not found")
+		return
+	}
+
+	if !certCoversDomain(cert, req.Domain) {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeCertDomainMatch, "cert does not match domain")
+		return
+	}
+
+	domain.CertID = req.CertID
+	domain.UpdateTime = time.Now().Unix()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"code": http.StatusOK, "error": ""})
+}
+
+// findBucketDomain returns the custom domain of any bucket, or nil if not found
+func (s *Server) findBucketDomain(name string) *qiniuapi.BucketDomain {
+	for _, domains := range s.buckets {
+		for _, domain := range domains {
+			if domain.Domain == name {
+				return domain
+			}
+		}
+	}
+	return nil
+}
+
+// listPiliHubs handles GET /v2/hubs
+func (s *Server) listPiliHubs(w http.ResponseWriter) {
+	hubs := make([]qiniuapi.PiliHub, 0, len(s.hubs))
+	for hub := range s.hubs {
+		hubs = append(hubs, qiniuapi.PiliHub{Name: hub})
+	}
+	sort.Slice(hubs, func(i, j int) bool { return hubs[i].Name < hubs[j].Name })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": hubs})
+}
+
+// listPiliDomains handles GET /v2/hubs/{hub}/domains
+func (s *Server) listPiliDomains(w http.ResponseWriter, hub string) {
+	domains, ok := s.hubs[hub]
+	if !ok {
+		writeError(w, http.StatusNotFound, http.StatusNotFound, "hub not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"domains": domains})
+}
+
+// getPiliDomain handles GET /v2/hubs/{hub}/domains/{domain}
+func (s *Server) getPiliDomain(w http.ResponseWriter, hub, name string) {
+	domain := s.findPiliDomain(hub, name)
+	if domain == nil {
+		writeError(w, http.StatusNotFound, http.StatusNotFound, "domain not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, domain)
+}
+
+// bindPiliDomainCertificate handles POST /v2/hubs/{hub}/domains/{domain}/cert
+func (s *Server) bindPiliDomainCertificate(w http.ResponseWriter, r *http.Request, hub, name string) {
+	var req struct {
+		CertID string `json:"certId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "invalid request body")
+		return
+	}
+
+	domain := s.findPiliDomain(hub, name)
+	if domain == nil {
+		writeError(w, http.StatusNotFound, http.StatusNotFound, "domain not found")
+		return
+	}
+
+	cert, ok := s.certs[req.CertID]
+	if !ok {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeCertNotFound, "cert not found")
+		return
+	}
+
+	if !certCoversDomain(cert, name) {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeCertDomainMatch, "cert does not match domain")
+		return
+	}
+
+	domain.CertEnable = true
+	domain.CertID = req.CertID
+	writeJSON(w, http.StatusOK, map[string]interface{}{"code": http.StatusOK, "error": ""})
+}
+
+// findPiliDomain returns the domain of a live-streaming hub, or nil if not found
+func (s *Server) findPiliDomain(hub, name string) *qiniuapi.PiliDomain {
+	for _, domain := range s.hubs[hub] {
+		if domain.Domain == name {
+			return domain
+		}
+	}
+	return nil
+}
+
+// startOperation puts the domain into the processing state for the configured duration
+func (s *Server) startOperation(info *qiniuapi.DomainInfo, operationType string) {
+	info.OperationType = operationType
+	info.OperatingState = qiniuapi.OperatingStateProcessing
+	info.OperatingStateDesc = ""
+	info.ModifyAt = time.Now().Format(time.RFC3339)
+	s.processingUntil[info.Name] = time.Now().Add(s.processingDuration)
+	s.settle(info)
+}
+
+// settle finishes the operation on the domain if its processing time has passed
+func (s *Server) settle(info *qiniuapi.DomainInfo) {
+	if !info.IsProcessing() || time.Now().Before(s.processingUntil[info.Name]) {
+		return
+	}
+
+	info.OperatingState = qiniuapi.OperatingStateSuccess
+	if len(s.operationFailures) > 0 {
+		info.OperatingState = qiniuapi.OperatingStateFailed
+		info.OperatingStateDesc = s.operationFailures[0]
+		s.operationFailures = s.operationFailures[1:]
+	}
+}
+
+// checkNotProcessing writes an error response if the domain is being processed
+func (s *Server) checkNotProcessing(w http.ResponseWriter, info *qiniuapi.DomainInfo) bool {
+	s.settle(info)
+	if info.IsDisabled() {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "domain is "+info.OperatingState)
+		return false
+	}
+	if info.IsProcessing() {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeDomainProcessing, "domain is processing, please try again later")
+		return false
+	}
+	return true
+}
+
+// decodeHTTPSConfig decodes and validates the HTTPS configuration in a request body
+func (s *Server) decodeHTTPSConfig(w http.ResponseWriter, r *http.Request, name string) (*qiniuapi.HTTPSInfo, bool) {
+	var https qiniuapi.HTTPSInfo
+	if err := json.NewDecoder(r.Body).Decode(&https); err != nil {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "invalid args")
+		return nil, false
+	}
+
+	cert, ok := s.certs[https.CertID]
+	if !ok {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeCertNotFound, "cert not found")
+		return nil, false
+	}
+
+	if !certCoversDomain(cert, name) {
+		writeError(w, http.StatusBadRequest, qiniuapi.CodeCertDomainMatch, "cert does not match domain")
+		return nil, false
+	}
+
+	return &https, true
+}
+
+// certCoversDomain checks whether one of the certificate names matches domain
+func certCoversDomain(cert *qiniuapi.CertificateInfo, domain string) bool {
+	for _, name := range cert.DNSNames {
+		if name == domain {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") {
+			if i := strings.Index(domain, "."); i > 0 && domain[i+1:] == name[2:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseLeaf parses the first certificate in a PEM bundle
+func parseLeaf(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// page parses the marker and limit query parameters of a list request over n items.
+// The marker is the index of the first item of the page.
+func page(w http.ResponseWriter, r *http.Request, n int) (start, end int, next string, ok bool) {
+	if marker := r.URL.Query().Get("marker"); marker != "" {
+		var err error
+		if start, err = strconv.Atoi(marker); err != nil || start < 0 || start > n {
+			writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "invalid marker")
+			return 0, 0, "", false
+		}
+	}
+
+	limit := 10
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 || limit > 1000 {
+			writeError(w, http.StatusBadRequest, qiniuapi.CodeInvalidArgs, "invalid limit")
+			return 0, 0, "", false
+		}
+	}
+
+	end = start + limit
+	if end >= n {
+		return start, n, "", true
+	}
+	return start, end, strconv.Itoa(end), true
+}
+
+// writeError writes an error response in the format of the Qiniu API
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"code":  code,
+		"error": message,
+	})
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Reqid", fmt.Sprintf("fake%d", time.Now().UnixNano()))
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
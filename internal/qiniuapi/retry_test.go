@@ -0,0 +1,25 @@
+package qiniuapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		want    time.Duration
+	}{
+		{time.Millisecond, 2 * time.Millisecond},
+		{time.Second, 2 * time.Second},
+		{10 * time.Second, 20 * time.Second},
+		{20 * time.Second, maxRetryBackoff},
+		{maxRetryBackoff, maxRetryBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := nextBackoff(tt.backoff); got != tt.want {
+			t.Errorf("nextBackoff(%s) = %s, want %s", tt.backoff, got, tt.want)
+		}
+	}
+}
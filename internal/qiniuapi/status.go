@@ -0,0 +1,168 @@
+package qiniuapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultCheckConcurrency is the default number of concurrent requests made by CheckCertificates
+const DefaultCheckConcurrency = 4
+
+// CertificateStatus is the state of the certificate bound to a domain
+type CertificateStatus struct {
+	Domain       string
+	DomainInfo   *DomainInfo      // as listed, without the HTTPS configuration; nil if not found or not a CDN domain
+	Certificate  *CertificateInfo // nil if no certificate is bound or it could not be retrieved
+	NeedsRenewal bool
+	Err          error // why the certificate could not be checked
+}
+
+// CheckCertificates checks the certificates bound to many domains at once, like CheckCertificateFromQiniu.
+// Domains are listed once and every certificate is retrieved only once, no matter how many domains
+// share it, with at most concurrency requests in flight.
+// Failures to check a single domain are reported in its status, and the domain is marked for renewal
+// unless it is disabled.
+func (q *QiniuClient) CheckCertificates(ctx context.Context, domains []string, thresholdDays, concurrency int) (map[string]*CertificateStatus, error) {
+	if len(domains) == 0 {
+		// Nothing to check, not even the domain list
+		return map[string]*CertificateStatus{}, nil
+	}
+
+	listed, err := q.ListAllDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.CheckListedCertificates(ctx, listed, domains, thresholdDays, concurrency)
+}
+
+// CheckListedCertificates is like CheckCertificates, for callers that already listed
+// all domains of the account with ListAllDomains
+func (q *QiniuClient) CheckListedCertificates(ctx context.Context, listed []DomainInfo, domains []string, thresholdDays, concurrency int) (map[string]*CertificateStatus, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultCheckConcurrency
+	}
+
+	infos := make(map[string]*DomainInfo, len(listed))
+	for i := range listed {
+		infos[listed[i].Name] = &listed[i]
+	}
+
+	statuses := make(map[string]*CertificateStatus, len(domains))
+	var httpsDomains []string
+	for _, domain := range domains {
+		status := &CertificateStatus{Domain: domain, NeedsRenewal: true}
+		statuses[domain] = status
+
+		info, ok := infos[domain]
+		status.DomainInfo = info
+		switch {
+		case !ok:
+			status.Err = fmt.Errorf("domain not found in Qiniu")
+		case info.IsDisabled():
+			status.NeedsRenewal = false
+			status.Err = fmt.Errorf("domain is disabled (state: %s, account frozen: %t)", info.OperatingState, info.UIDIsFreezed)
+		case info.Protocol != "https":
+			status.Err = fmt.Errorf("domain does not have HTTPS enabled or certificate bound")
+		default:
+			httpsDomains = append(httpsDomains, domain)
+		}
+	}
+
+	// The domain list does not include the HTTPS configuration
+	certIDs := make(map[string]string, len(httpsDomains))
+	var mu sync.Mutex
+	forEach(ctx, httpsDomains, concurrency, func(domain string) {
+		info, err := q.GetDomainInfo(ctx, domain)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			statuses[domain].Err = fmt.Errorf("failed to get domain info: %w", err)
+		case info.HTTPS == nil || info.HTTPS.CertID == "":
+			statuses[domain].Err = fmt.Errorf("domain does not have HTTPS enabled or certificate bound")
+		default:
+			certIDs[domain] = info.HTTPS.CertID
+		}
+	})
+
+	if err := q.checkExpiry(ctx, statuses, certIDs, thresholdDays, concurrency); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// checkExpiry retrieves the certificates bound to domains, given by domain, and fills
+// their statuses. Every certificate is retrieved once, no matter how many domains share it.
+func (q *QiniuClient) checkExpiry(ctx context.Context, statuses map[string]*CertificateStatus, certIDs map[string]string, thresholdDays, concurrency int) error {
+	var uniqueIDs []string
+	seen := make(map[string]bool)
+	for _, certID := range certIDs {
+		if !seen[certID] {
+			seen[certID] = true
+			uniqueIDs = append(uniqueIDs, certID)
+		}
+	}
+
+	var mu sync.Mutex
+	certs := make(map[string]*CertificateInfo, len(uniqueIDs))
+	certErrs := make(map[string]error)
+	forEach(ctx, uniqueIDs, concurrency, func(certID string) {
+		cert, err := q.GetCertificateInfo(ctx, certID)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			certErrs[certID] = fmt.Errorf("failed to get certificate info: %w", err)
+			return
+		}
+		certs[certID] = cert
+	})
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	thresholdTime := time.Now().AddDate(0, 0, thresholdDays)
+	for domain, certID := range certIDs {
+		status := statuses[domain]
+		if err, ok := certErrs[certID]; ok {
+			status.Err = err
+			continue
+		}
+
+		status.Certificate = certs[certID]
+		status.NeedsRenewal = status.Certificate.NotAfter < thresholdTime.Unix()
+	}
+
+	return nil
+}
+
+// forEach calls fn for every item with at most concurrency calls running at the same time.
+// It stops starting new calls once ctx is done.
+func forEach(ctx context.Context, items []string, concurrency int, fn func(string)) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for _, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(item string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(item)
+		}(item)
+	}
+
+	wg.Wait()
+}
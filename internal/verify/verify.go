@@ -0,0 +1,159 @@
+package verify
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WqyJh/qiniu-ssl/internal/qiniuapi"
+)
+
+const (
+	// defaultPort is the port dialed when the target does not include one
+	defaultPort = "443"
+
+	// dialTimeout bounds a single TLS handshake
+	dialTimeout = 10 * time.Second
+)
+
+// MismatchError reports edge addresses that still serve a different certificate
+// than the deployed one after the propagation deadline
+type MismatchError struct {
+	Domain string            // Domain used for SNI
+	Want   string            // SHA-256 fingerprint of the deployed certificate
+	Served map[string]string // Fingerprint served by each mismatching address, or the dial or resolve error
+}
+
+func (e *MismatchError) Error() string {
+	addrs := make([]string, 0, len(e.Served))
+	for addr := range e.Served {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	served := make([]string, len(addrs))
+	for i, addr := range addrs {
+		served[i] = fmt.Sprintf("%s: %s", addr, e.Served[addr])
+	}
+	return fmt.Sprintf("%s is still not serving the deployed certificate %s (%s)",
+		e.Domain, e.Want, strings.Join(served, ", "))
+}
+
+// ServedFingerprint performs a TLS handshake with addr using domain for SNI
+// and returns the SHA-256 fingerprint of the leaf certificate served
+func ServedFingerprint(ctx context.Context, addr, domain string) (string, error) {
+	cert, err := ServedCertificate(ctx, addr, domain)
+	if err != nil {
+		return "", err
+	}
+
+	return qiniuapi.Fingerprint(cert), nil
+}
+
+// ServedCertificate performs a TLS handshake with addr using domain for SNI
+// and returns the leaf certificate served, without verifying it
+func ServedCertificate(ctx context.Context, addr, domain string) (*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: dialTimeout},
+		Config: &tls.Config{
+			ServerName: domain,
+			// Only the fingerprint matters, an old or mismatching certificate must not fail the handshake
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate served")
+	}
+
+	return certs[0], nil
+}
+
+// WaitForCertificate checks every interval for at most timeout that all addresses of target
+// serve the certificate with the given fingerprint for domain. Target is a host name or IP
+// address, with an optional port, and defaults to the domain itself.
+// It returns a *MismatchError listing the addresses still serving another certificate.
+func WaitForCertificate(ctx context.Context, domain, target, fingerprint string, timeout, interval time.Duration) error {
+	if target == "" {
+		target = domain
+	}
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = target, defaultPort
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		mismatches, err := check(ctx, domain, host, port, fingerprint)
+		if err != nil {
+			return err
+		}
+
+		if len(mismatches) == 0 {
+			return nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return &MismatchError{Domain: domain, Want: fingerprint, Served: mismatches}
+		}
+
+		log.Printf("%d edge addresses of %s are still serving another certificate, checking again in %s...",
+			len(mismatches), domain, interval)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// check dials every address of host and returns the ones not serving the certificate.
+// A host that cannot be resolved is returned as a mismatch, DNS may still be propagating.
+func check(ctx context.Context, domain, host, port, fingerprint string) (map[string]string, error) {
+	mismatches := make(map[string]string)
+
+	addrs := []string{host}
+	if net.ParseIP(host) == nil {
+		var err error
+		if addrs, err = net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			mismatches[host] = fmt.Sprintf("failed to resolve: %v", err)
+			return mismatches, nil
+		}
+	}
+
+	for _, addr := range addrs {
+		addr = net.JoinHostPort(addr, port)
+		served, err := ServedFingerprint(ctx, addr, domain)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			mismatches[addr] = err.Error()
+			continue
+		}
+
+		if served != fingerprint {
+			mismatches[addr] = served
+		}
+	}
+
+	return mismatches, nil
+}